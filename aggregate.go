@@ -0,0 +1,419 @@
+package cete
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/1lann/badger"
+	"github.com/1lann/msgpack"
+)
+
+type accumulatorKind int
+
+const (
+	countAccumulator accumulatorKind = iota
+	sumAccumulator
+	minAccumulator
+	maxAccumulator
+	avgAccumulator
+	distinctAccumulator
+)
+
+// Accumulator computes a value from the documents of each group of an
+// aggregation. Create one with Count, Sum, Min, Max, Avg or Distinct.
+type Accumulator struct {
+	kind  accumulatorKind
+	query string
+}
+
+// Count counts the number of documents, as an int64.
+func Count() Accumulator {
+	return Accumulator{kind: countAccumulator}
+}
+
+// Sum adds up the numeric values of the query, as a float64. Documents
+// whose value isn't a number are skipped.
+func Sum(query string) Accumulator {
+	return Accumulator{kind: sumAccumulator, query: query}
+}
+
+// Min finds the lowest value of the query. Numbers are compared with each
+// other by their value, and other values are compared in the same way as
+// indexes order them. It is nil if none of the documents have a value.
+func Min(query string) Accumulator {
+	return Accumulator{kind: minAccumulator, query: query}
+}
+
+// Max finds the highest value of the query. See Min for details.
+func Max(query string) Accumulator {
+	return Accumulator{kind: maxAccumulator, query: query}
+}
+
+// Avg averages the numeric values of the query, as a float64. Documents
+// whose value isn't a number are skipped, and it is nil if none of the
+// documents have a numeric value.
+func Avg(query string) Accumulator {
+	return Accumulator{kind: avgAccumulator, query: query}
+}
+
+// Distinct finds the distinct values of the query, as a []interface{} in
+// the order that indexes order them. Like with indexes, strings which only
+// differ by case are the same value.
+func Distinct(query string) Accumulator {
+	return Accumulator{kind: distinctAccumulator, query: query}
+}
+
+// Group is a group of documents of an aggregation.
+type Group struct {
+	// Key is the value of each GroupBy query of the documents in the group,
+	// or nil if the aggregation isn't grouped.
+	Key []interface{}
+
+	// Values is the value of each of the aggregation's accumulators, in the
+	// order they were given.
+	Values []interface{}
+}
+
+// Aggregation represents an aggregation of the documents of a range.
+// Create one with Range.Aggregate, and run it with Run.
+type Aggregation struct {
+	r            *Range
+	groupBy      []string
+	accumulators []Accumulator
+}
+
+// Aggregate returns an aggregation of the documents of the range, which
+// computes the value of each accumulator. The values are read with
+// Document.QueryOne.
+//
+// Aggregations always read every document of the range, even when grouping
+// by an indexed field. To count the documents with each value of an indexed
+// field, use Index.Values instead, which reads the counts from the index
+// without reading any documents.
+func (r *Range) Aggregate(accumulators ...Accumulator) *Aggregation {
+	return &Aggregation{r: r, accumulators: accumulators}
+}
+
+// GroupBy groups the documents by the values of the queries, and computes
+// the accumulators for each group. Like with indexes, strings which only
+// differ by case are in the same group. Documents whose value can't be
+// indexed, such as maps, are skipped.
+func (a *Aggregation) GroupBy(queries ...string) *Aggregation {
+	a.groupBy = append(a.groupBy, queries...)
+	return a
+}
+
+// accumulatorState is the state of an accumulator for a group.
+type accumulatorState struct {
+	count    int64
+	sum      float64
+	best     interface{}
+	bestKey  []byte
+	distinct map[string]interface{}
+}
+
+type groupState struct {
+	key    []interface{}
+	states []*accumulatorState
+}
+
+// Run reads the whole range, and returns the groups in the order of their
+// keys. If the aggregation isn't grouped, a single group is returned.
+func (a *Aggregation) Run() ([]Group, error) {
+	groups := make(map[string]*groupState)
+
+	if len(a.groupBy) == 0 {
+		groups[""] = a.newGroup(nil)
+	}
+
+	defer a.r.Close()
+
+	for a.r.Next() {
+		doc := a.r.Document()
+
+		var groupKey []byte
+		var key []interface{}
+		skip := false
+
+		for _, query := range a.groupBy {
+			value := doc.QueryOne(query)
+			b, err := encodeValue(value, lowerString, currentEncoding)
+			if err != nil {
+				skip = true
+				break
+			}
+
+			groupKey = append(groupKey, b...)
+			key = append(key, derefTime(value))
+		}
+
+		if skip {
+			continue
+		}
+
+		group := groups[string(groupKey)]
+		if group == nil {
+			group = a.newGroup(key)
+			groups[string(groupKey)] = group
+		}
+
+		for n, acc := range a.accumulators {
+			group.states[n].add(acc, doc)
+		}
+	}
+
+	if a.r.Error() != ErrEndOfRange {
+		return nil, a.r.Error()
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]Group, len(keys))
+	for n, key := range keys {
+		group := groups[key]
+		results[n].Key = group.key
+		results[n].Values = make([]interface{}, len(a.accumulators))
+		for m, acc := range a.accumulators {
+			results[n].Values[m] = group.states[m].result(acc)
+		}
+	}
+
+	return results, nil
+}
+
+func (a *Aggregation) newGroup(key []interface{}) *groupState {
+	group := &groupState{key: key}
+	for range a.accumulators {
+		group.states = append(group.states, &accumulatorState{})
+	}
+
+	return group
+}
+
+func (s *accumulatorState) add(acc Accumulator, doc Document) {
+	if acc.kind == countAccumulator {
+		s.count++
+		return
+	}
+
+	value := derefTime(doc.QueryOne(acc.query))
+
+	switch acc.kind {
+	case sumAccumulator, avgAccumulator:
+		if f, ok := toFloat(value); ok {
+			s.sum += f
+			s.count++
+		}
+	case minAccumulator, maxAccumulator:
+		if value == nil {
+			return
+		}
+
+		b, err := encodeValue(value, lowerString, currentEncoding)
+		if err != nil {
+			return
+		}
+
+		if s.best == nil {
+			s.best, s.bestKey = value, b
+			return
+		}
+
+		c := compareValues(value, b, s.best, s.bestKey)
+		if (acc.kind == minAccumulator && c < 0) ||
+			(acc.kind == maxAccumulator && c > 0) {
+			s.best, s.bestKey = value, b
+		}
+	case distinctAccumulator:
+		if value == nil {
+			return
+		}
+
+		b, err := encodeValue(value, lowerString, currentEncoding)
+		if err != nil {
+			return
+		}
+
+		if s.distinct == nil {
+			s.distinct = make(map[string]interface{})
+		}
+
+		if _, found := s.distinct[string(b)]; !found {
+			s.distinct[string(b)] = value
+		}
+	}
+}
+
+func (s *accumulatorState) result(acc Accumulator) interface{} {
+	switch acc.kind {
+	case countAccumulator:
+		return s.count
+	case sumAccumulator:
+		return s.sum
+	case avgAccumulator:
+		if s.count == 0 {
+			return nil
+		}
+		return s.sum / float64(s.count)
+	case minAccumulator, maxAccumulator:
+		return s.best
+	}
+
+	keys := make([]string, 0, len(s.distinct))
+	for key := range s.distinct {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]interface{}, len(keys))
+	for n, key := range keys {
+		values[n] = s.distinct[key]
+	}
+
+	return values
+}
+
+// compareValues compares a and b, whose index values are aKey and bKey.
+// Numbers are compared by their value, and other values by their index
+// values.
+func compareValues(a interface{}, aKey []byte, b interface{},
+	bKey []byte) int {
+	af, aNumber := toFloat(a)
+	bf, bNumber := toFloat(b)
+
+	if aNumber && bNumber {
+		if af < bf {
+			return -1
+		} else if af > bf {
+			return 1
+		}
+		return 0
+	}
+
+	return bytes.Compare(aKey, bKey)
+}
+
+// toFloat converts a number returned by a query, of any integer or float
+// type, into a float64.
+func toFloat(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+
+	return 0, false
+}
+
+// Bucket is the number of documents with an index value.
+type Bucket struct {
+	// Value is the index value. Documents whose values only differ in ways
+	// which the index ignores, such as strings which only differ by case,
+	// are in the same bucket.
+	Value interface{}
+	Count int64
+}
+
+// Histogram returns the number of documents with each index value within
+// the given bounds, in the order of the index. Like CountBetween, the
+// counts are read from the index, and the Value of each bucket is the value
+// of one of its documents, so one document is read per bucket. Use Values to
+// read the values from the index instead, without reading any documents.
+// Expired documents are counted until they are deleted from the index.
+func (i *Index) Histogram(lower, upper interface{}) ([]Bucket, error) {
+	if i.building() {
+		return nil, ErrIndexBuilding
+	}
+
+	ranges, err := i.betweenRanges(lower, upper)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Bucket
+
+	i.scanRanges(ranges, false, func(key, value []byte) bool {
+		if len(value) == 0 {
+			return true
+		}
+
+		var bucketValue interface{}
+		if bucketValue, err = i.bucketValue(key, value); err != nil {
+			return false
+		}
+
+		results = append(results, Bucket{
+			Value: bucketValue,
+			Count: decodeArrayCount(value),
+		})
+
+		return true
+	})
+
+	return results, err
+}
+
+// bucketValue returns the value of the first of the documents in the list
+// of keys of the index value which hasn't expired. Only as many keys as are
+// needed are decoded from the list.
+func (i *Index) bucketValue(indexKey, list []byte) (interface{}, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(list))
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, i.indexError("", err)
+	}
+
+	var item badger.KVItem
+
+	for ; n > 0; n-- {
+		key, err := dec.DecodeString()
+		if err != nil {
+			return nil, i.indexError("", err)
+		}
+
+		if err = i.table.data.Get([]byte(key), &item); err != nil {
+			return nil, err
+		}
+
+		itemValue := getItemValue(&item)
+		if itemValue == nil || itemExpired(&item) {
+			continue
+		}
+
+		data := make([]byte, len(itemValue))
+		copy(data, itemValue)
+
+		values, _ := i.values(data)
+		for _, value := range values {
+			if b, err := i.valueToBytes(value); err == nil &&
+				bytes.Equal(b, indexKey) {
+				return derefTime(value), nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// derefTime returns the time.Time of a *time.Time returned by a query, so
+// results don't share a pointer with the decoded document.
+func derefTime(value interface{}) interface{} {
+	if t, ok := value.(*time.Time); ok && t != nil {
+		return *t
+	}
+
+	return value
+}
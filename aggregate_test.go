@@ -0,0 +1,154 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("aggregate_testing"))
+	table := db.Table("aggregate_testing")
+	panicNotNil(table.NewIndex("City"))
+	panicNotNil(table.NewIndex("Likes.*"))
+
+	people := map[string]Person{
+		"ben":   {Name: "Ben", City: "Melbourne", Age: 19, Height: 180, Likes: []string{"c", "go"}},
+		"drew":  {Name: "Drew", City: "London", Age: 17, Height: 165, Likes: []string{"js"}},
+		"jason": {Name: "Jason", City: "Sydney", Age: 18, Height: 172.5, Likes: []string{"go", "js"}},
+		"kevin": {Name: "Kevin", City: "sydney", Age: 25, Height: 190, Likes: []string{"rust"}},
+		"mark":  {Name: "Mark", City: "Sydney", Age: 16, Height: 150, Likes: []string{"go"}},
+	}
+
+	for key, person := range people {
+		panicNotNil(table.Set(key, person))
+	}
+
+	groups, err := table.All().Aggregate(Count(), Sum("Age"), Min("Age"),
+		Max("Name"), Avg("Height"), Distinct("Name")).GroupBy("City").Run()
+	panicNotNil(err)
+
+	if len(groups) != 3 {
+		t.Fatal("there should be 3 groups, but there are", len(groups))
+	}
+
+	expectGroup := func(group Group, key interface{}, values ...interface{}) {
+		t.Helper()
+
+		if len(group.Key) != 1 || group.Key[0] != key {
+			t.Fatal("group key should be", key, "but is", group.Key)
+		}
+
+		if !reflect.DeepEqual(group.Values, values) {
+			t.Fatal("group values should be", values, "but are", group.Values)
+		}
+	}
+
+	expectGroup(groups[0], "London", int64(1), float64(17), int64(17),
+		"Drew", float64(165), []interface{}{"Drew"})
+	expectGroup(groups[1], "Melbourne", int64(1), float64(19), int64(19),
+		"Ben", float64(180), []interface{}{"Ben"})
+
+	if groups[2].Key[0] != "Sydney" && groups[2].Key[0] != "sydney" {
+		t.Fatal("group key should be Sydney, but is", groups[2].Key)
+	}
+	expectGroup(groups[2], groups[2].Key[0], int64(3), float64(59),
+		int64(16), "Mark", float64(512.5)/3,
+		[]interface{}{"Jason", "Kevin", "Mark"})
+
+	groups, err = table.Index("City").GetAll("Sydney").
+		Aggregate(Count(), Min("Height"), Avg("Name")).Run()
+	panicNotNil(err)
+
+	if len(groups) != 1 || groups[0].Key != nil {
+		t.Fatal("there should be 1 group without a key, but there are",
+			groups)
+	}
+
+	if !reflect.DeepEqual(groups[0].Values,
+		[]interface{}{int64(3), float64(150), nil}) {
+		t.Fatal("values should be [3 150 <nil>], but are", groups[0].Values)
+	}
+
+	type sizes struct {
+		Small  int32
+		Medium uint16
+		Large  uint32
+	}
+
+	panicNotNil(db.NewTable("sizes_testing"))
+	sizesTable := db.Table("sizes_testing")
+	panicNotNil(sizesTable.Set("a", sizes{Small: -300, Medium: 8080,
+		Large: 70000}))
+	panicNotNil(sizesTable.Set("b", sizes{Small: 70000, Medium: 200,
+		Large: 5}))
+
+	groups, err = sizesTable.All().Aggregate(Sum("Small"), Avg("Medium"),
+		Sum("Large"), Min("Small"), Max("Medium")).Run()
+	panicNotNil(err)
+
+	if len(groups) != 1 || !reflect.DeepEqual(groups[0].Values[:3],
+		[]interface{}{float64(69700), float64(4140), float64(70005)}) {
+		t.Fatal("values should start with [69700 4140 70005], but are",
+			groups)
+	}
+
+	if min, _ := toFloat(groups[0].Values[3]); min != -300 {
+		t.Fatal("min should be -300, but is", groups[0].Values[3])
+	}
+
+	if max, _ := toFloat(groups[0].Values[4]); max != 8080 {
+		t.Fatal("max should be 8080, but is", groups[0].Values[4])
+	}
+
+	buckets, err := table.Index("Likes.*").Histogram(MinValue, MaxValue)
+	panicNotNil(err)
+
+	expected := []Bucket{{"c", 1}, {"go", 3}, {"js", 2}, {"rust", 1}}
+	if !reflect.DeepEqual(buckets, expected) {
+		t.Fatal("buckets should be", expected, "but are", buckets)
+	}
+
+	buckets, err = table.Index("City").Histogram("m", MaxValue)
+	panicNotNil(err)
+
+	if len(buckets) != 2 || buckets[0].Value != "Melbourne" ||
+		buckets[1].Count != 3 {
+		t.Fatal("buckets should be [{Melbourne 1} {Sydney 3}], but are",
+			buckets)
+	}
+
+	// The value of an expired document isn't used for its bucket.
+	panicNotNil(table.SetWithTTL("zed", Person{City: "PERTH"},
+		time.Millisecond))
+	panicNotNil(table.Set("yan", Person{City: "Perth"}))
+	time.Sleep(time.Millisecond * 10)
+
+	buckets, err = table.Index("City").Histogram("perth", "perth")
+	panicNotNil(err)
+
+	if len(buckets) != 1 || buckets[0].Value != "Perth" {
+		t.Fatal("buckets should be [{Perth 1}], but are", buckets)
+	}
+}
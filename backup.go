@@ -0,0 +1,238 @@
+package cete
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/1lann/badger"
+	"github.com/1lann/badger/options"
+	"github.com/1lann/msgpack"
+)
+
+const (
+	backupMagic   = "cete backup"
+	backupVersion = 1
+)
+
+var errBadBackup = errors.New("cete: invalid backup")
+
+type backupHeader struct {
+	Magic   string
+	Version int
+	Config  dbConfig
+}
+
+type backupStore struct {
+	table string
+	index string
+}
+
+// backupRecord represents a key-value pair in one of the stores of the
+// database. Records of the journal have an empty Table, and the last record
+// of a backup has End set to true.
+type backupRecord struct {
+	Table    string
+	Index    string
+	Key      []byte
+	Value    []byte
+	UserMeta byte
+	End      bool
+}
+
+// Backup writes a consistent snapshot of the database to w, including all
+// of its tables, indexes and configuration. Writes to the database are
+// blocked while the backup is being written, but reads are not. Use Restore
+// to recreate a database from the backup.
+func (d *DB) Backup(w io.Writer) error {
+	d.commitLock.Lock()
+	defer d.commitLock.Unlock()
+
+	d.configMutex.Lock()
+	config, err := msgpack.Marshal(d.config)
+	d.configMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	header := backupHeader{
+		Magic:   backupMagic,
+		Version: backupVersion,
+	}
+
+	if err = msgpack.Unmarshal(config, &header.Config); err != nil {
+		return err
+	}
+
+	enc := msgpack.NewEncoder(w)
+	if err = enc.Encode(header); err != nil {
+		return err
+	}
+
+	for _, table := range header.Config.Tables {
+		t := d.tables[Name(table.TableName)]
+		if t == nil {
+			continue
+		}
+
+		err = backupKV(enc, t.data, backupRecord{Table: table.TableName})
+		if err != nil {
+			return err
+		}
+
+		for _, index := range table.Indexes {
+			i := t.indexes[Name(index.IndexName)]
+			if i == nil {
+				continue
+			}
+
+			err = backupKV(enc, i.index, backupRecord{
+				Table: table.TableName,
+				Index: index.IndexName,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if d.journal != nil {
+		if err = backupKV(enc, d.journal, backupRecord{}); err != nil {
+			return err
+		}
+	}
+
+	return enc.Encode(backupRecord{End: true})
+}
+
+func backupKV(enc *msgpack.Encoder, kv *badger.KV, record backupRecord) error {
+	itOpts := badger.DefaultIteratorOptions
+	itOpts.PrefetchSize = prefetchSize
+	it := kv.NewIterator(itOpts)
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		err := it.Item().Value(func(value []byte) error {
+			record.Key = it.Item().Key()
+			record.Value = value
+			record.UserMeta = it.Item().UserMeta()
+			return enc.Encode(record)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore recreates a database at the given path from a backup written by
+// DB.Backup. ErrAlreadyExists is returned if the path already exists. The
+// restored database can then be opened with Open. Like Open, badger options
+// may optionally be provided, which are used while the database is restored.
+func Restore(r io.Reader, path string, opts ...badger.Options) error {
+	if ex, _ := exists(path); ex {
+		return ErrAlreadyExists
+	}
+
+	dec := msgpack.NewDecoder(r)
+
+	var header backupHeader
+	if err := dec.Decode(&header); err != nil {
+		return errors.New("cete: failed to read backup: " + err.Error())
+	}
+
+	if header.Magic != backupMagic || header.Version != backupVersion {
+		return errBadBackup
+	}
+
+	defaultOpts := badger.DefaultOptions
+	defaultOpts.TableLoadingMode = options.MemoryMap
+
+	db := &DB{
+		path:        path,
+		config:      header.Config,
+		configMutex: new(sync.Mutex),
+		openOptions: defaultOpts,
+		done:        make(chan struct{}),
+	}
+
+	if len(opts) > 0 {
+		db.openOptions = opts[0]
+	}
+
+	if err := os.MkdirAll(path, 0744); err != nil {
+		return errors.New("cete: failed to create database: " + err.Error())
+	}
+
+	kvs := make(map[backupStore]*badger.KV)
+	err := db.restoreRecords(dec, kvs)
+
+	for _, kv := range kvs {
+		kv.Close()
+	}
+	atomic.StoreInt32(&db.closed, 1)
+	close(db.done)
+
+	if err == nil {
+		err = db.writeConfig()
+	}
+
+	if err != nil {
+		os.RemoveAll(path)
+		return err
+	}
+
+	return nil
+}
+
+func (d *DB) restoreRecords(dec *msgpack.Decoder,
+	kvs map[backupStore]*badger.KV) error {
+	generations := make(map[backupStore]int)
+	for _, table := range d.config.Tables {
+		for _, index := range table.Indexes {
+			generations[backupStore{table.TableName, index.IndexName}] =
+				index.Generation
+		}
+	}
+
+	for {
+		var record backupRecord
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				return errBadBackup
+			}
+
+			return errors.New("cete: failed to read backup: " + err.Error())
+		}
+
+		if record.End {
+			return nil
+		}
+
+		store := backupStore{record.Table, record.Index}
+		kv, found := kvs[store]
+		if !found {
+			var err error
+			if store.table == "" {
+				kv, err = d.openKV(d.path + "/journal")
+			} else if store.index == "" {
+				kv, err = d.newKV(Name(store.table))
+			} else {
+				kv, err = d.openKV(d.indexPath(store.table, store.index,
+					generations[store]))
+			}
+			if err != nil {
+				return err
+			}
+
+			kvs[store] = kv
+		}
+
+		if err := kv.Set(record.Key, record.Value, record.UserMeta); err != nil {
+			return err
+		}
+	}
+}
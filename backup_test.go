@@ -0,0 +1,118 @@
+package cete
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("backup_testing"))
+	panicNotNil(db.NewTable("backup_uncompressed", false))
+	table := db.Table("backup_testing")
+	panicNotNil(table.NewIndex("Age"))
+	panicNotNil(table.NewUniqueIndex("Name"))
+
+	for i := 0; i < 100; i++ {
+		panicNotNil(table.Set(fmt.Sprintf("person%03d", i), Person{
+			Name: fmt.Sprintf("Person %d", i),
+			Age:  i % 10,
+		}))
+	}
+
+	panicNotNil(db.Table("backup_uncompressed").Set("jason",
+		Person{Name: "Jason"}))
+
+	var buf bytes.Buffer
+	panicNotNil(db.Backup(&buf))
+
+	// Writes after the backup should not be in the backup.
+	panicNotNil(table.Set("person100", Person{Name: "Person 100"}))
+	panicNotNil(table.Delete("person000"))
+
+	db.Close()
+
+	backup := buf.Bytes()
+
+	err = Restore(bytes.NewReader(backup), dir+"/data")
+	if err != ErrAlreadyExists {
+		t.Fatal("error should be ErrAlreadyExists, but is", err)
+	}
+
+	err = Restore(bytes.NewReader(backup[:len(backup)/2]), dir+"/truncated")
+	if err == nil {
+		t.Fatal("error should not be nil, but is")
+	}
+
+	if found, _ := exists(dir + "/truncated"); found {
+		t.Fatal("truncated restore should have been removed, but wasn't")
+	}
+
+	panicNotNil(Restore(bytes.NewReader(backup), dir+"/restored"))
+
+	db, err = Open(dir + "/restored")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("backup_testing")
+	if count := table.CountBetween(MinValue, MaxValue); count != 100 {
+		t.Fatal("count should be 100, but is", count)
+	}
+
+	var person Person
+	_, err = table.Get("person000", &person)
+	panicNotNil(err)
+	if person.Name != "Person 0" {
+		t.Fatal("name should be Person 0, but is", person.Name)
+	}
+
+	_, err = table.Get("person100", nil)
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	if count := table.Index("Age").CountBetween(3, 3); count != 10 {
+		t.Fatal("count should be 10, but is", count)
+	}
+
+	_, err = db.Table("backup_uncompressed").Get("jason", &person)
+	panicNotNil(err)
+	if person.Name != "Jason" {
+		t.Fatal("name should be Jason, but is", person.Name)
+	}
+
+	reports, err := db.Check()
+	panicNotNil(err)
+	for _, report := range reports {
+		if !report.OK() {
+			t.Fatalf("report should be ok, but isn't: %+v", report)
+		}
+	}
+
+	err = table.Set("person200", Person{Name: "Person 5"})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	panicNotNil(table.Set("person200", Person{Name: "Person 200", Age: 3}))
+}
@@ -0,0 +1,246 @@
+package cete
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/1lann/badger"
+)
+
+// The number of documents indexed between each checkpoint of an index build.
+// Writes to the database are blocked while a batch is being indexed.
+const buildBatchSize = 1000
+
+var errBuildStopped = errors.New("cete: index build stopped")
+
+// BuildStatus represents the progress of building an index.
+type BuildStatus struct {
+	// Building is true while the index is still being built.
+	Building bool
+	// Indexed is the number of documents that have been indexed.
+	Indexed int64
+	// Total is the number of documents that were in the table when the
+	// build started or resumed.
+	Total int64
+	// Err is the error that caused the build to fail, if any.
+	Err error
+}
+
+type indexBuild struct {
+	// checkpoint is the key of the last document that was indexed. It must
+	// only be accessed while holding the database's commit lock.
+	checkpoint string
+
+	// The following are protected by the index's lock.
+	indexed  int64
+	total    int64
+	finished bool
+	err      error
+
+	done chan struct{}
+}
+
+func newIndexBuild(checkpoint string) *indexBuild {
+	return &indexBuild{
+		checkpoint: checkpoint,
+		done:       make(chan struct{}),
+	}
+}
+
+// BuildStatus returns the progress of building the index. Building will be
+// false if the index has been built.
+func (i *Index) BuildStatus() BuildStatus {
+	if i.build == nil {
+		return BuildStatus{}
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	return BuildStatus{
+		Building: !i.build.finished && i.build.err == nil,
+		Indexed:  i.build.indexed,
+		Total:    i.build.total,
+		Err:      i.build.err,
+	}
+}
+
+// WaitForBuild waits for the index to finish building, and returns the error
+// that caused the build to fail, if any. ErrIndexBuilding is returned if the
+// database was closed before the build completed.
+func (i *Index) WaitForBuild() error {
+	if i.build == nil {
+		return nil
+	}
+
+	<-i.build.done
+
+	status := i.BuildStatus()
+	if status.Err != nil {
+		return status.Err
+	}
+
+	if status.Building {
+		return ErrIndexBuilding
+	}
+
+	return nil
+}
+
+func (i *Index) building() bool {
+	return i.BuildStatus().Building
+}
+
+// skipsKey returns true if writes to the document with the given key don't
+// need to update the index, as the document is yet to be indexed by the
+// index's build. The database's commit lock must be held by the caller.
+func (i *Index) skipsKey(key string) bool {
+	return i.building() && key > i.build.checkpoint
+}
+
+func (i *Index) runBuild(name string) {
+	var indexed int64
+	if i.build.checkpoint != "" {
+		indexed = i.table.countBetween(MinValue, i.build.checkpoint, true)
+	}
+	total := i.table.countBetween(MinValue, MaxValue, true)
+
+	i.lock.Lock()
+	i.build.indexed = indexed
+	i.build.total = total
+	i.lock.Unlock()
+
+	var err error
+	finished := false
+
+	for !finished && err == nil {
+		finished, err = i.buildBatch(name)
+	}
+
+	if err == errBuildStopped {
+		close(i.build.done)
+		return
+	}
+
+	if err == nil {
+		i.table.db.commitLock.RLock()
+		stale := i.stale
+		i.table.db.commitLock.RUnlock()
+
+		// The index is still usable if it fails to be rebuilt, and will
+		// be rebuilt again when the database is next opened.
+		if stale {
+			if rebuildErr := i.Rebuild(); rebuildErr != nil {
+				i.table.db.log("cete: failed to rebuild "+i.name()+":",
+					rebuildErr)
+			}
+		}
+	}
+
+	if err != nil {
+		i.lock.Lock()
+		i.build.err = err
+		i.lock.Unlock()
+
+		i.table.db.commitLock.Lock()
+		if i.table.indexes[Name(name)] == i {
+			i.drop(name)
+		}
+		i.table.db.commitLock.Unlock()
+	}
+
+	close(i.build.done)
+}
+
+// buildBatch indexes the next batch of documents after the checkpoint,
+// and returns true if there are no more documents to index.
+func (i *Index) buildBatch(name string) (bool, error) {
+	db := i.table.db
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	if atomic.LoadInt32(&db.closed) != 0 ||
+		i.table.indexes[Name(name)] != i {
+		return false, errBuildStopped
+	}
+
+	checkpoint, n, finished, err := i.indexBatch()
+	if err != nil {
+		return false, err
+	}
+
+	db.configMutex.Lock()
+	config := db.indexConfig(i.table.name(), name)
+	if config == nil {
+		db.configMutex.Unlock()
+		return false, ErrNotFound
+	}
+
+	config.Checkpoint = checkpoint
+	if finished {
+		config.Building = false
+		config.Checkpoint = ""
+	}
+
+	err = db.writeConfig()
+	db.configMutex.Unlock()
+	if err != nil {
+		return false, err
+	}
+
+	i.advanceBuild(checkpoint, n, finished)
+
+	return finished, nil
+}
+
+// indexBatch indexes the next batch of documents after the checkpoint of the
+// index's build. It returns the key of the last document indexed, the number
+// of documents indexed, and true if there are no more documents to index. The
+// database's commit lock must be held by the caller.
+func (i *Index) indexBatch() (string, int64, bool, error) {
+	itOpts := badger.DefaultIteratorOptions
+	itOpts.PrefetchSize = prefetchSize
+	it := i.table.data.NewIterator(itOpts)
+	defer it.Close()
+
+	checkpoint := i.build.checkpoint
+	if checkpoint == "" {
+		it.Rewind()
+	} else {
+		it.Seek([]byte(checkpoint))
+		if it.Valid() && string(it.Item().Key()) == checkpoint {
+			it.Next()
+		}
+	}
+
+	var n int64
+	for ; it.Valid() && n < buildBatchSize; it.Next() {
+		key := string(it.Item().Key())
+
+		results, err := i.values(getItemValue(it.Item()))
+		if err == nil {
+			for _, indexKey := range i.indexKeys(results) {
+				err = i.addToIndex(indexKey, key)
+				if err != nil {
+					return "", 0, false, err
+				}
+			}
+		}
+
+		checkpoint = key
+		n++
+	}
+
+	return checkpoint, n, !it.Valid(), nil
+}
+
+// advanceBuild moves the checkpoint of the index's build past a batch of
+// indexed documents. The database's commit lock must be held by the caller.
+func (i *Index) advanceBuild(checkpoint string, n int64, finished bool) {
+	i.build.checkpoint = checkpoint
+
+	i.lock.Lock()
+	i.build.indexed += n
+	i.build.finished = finished
+	i.lock.Unlock()
+}
@@ -0,0 +1,196 @@
+package cete
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackgroundBuild(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("build_testing"))
+	table := db.Table("build_testing")
+
+	for i := 0; i < 2500; i++ {
+		panicNotNil(table.Set(fmt.Sprintf("person%04d", i), Person{
+			Name: fmt.Sprintf("Person %d", i),
+			Age:  i % 50,
+		}))
+	}
+
+	panicNotNil(table.NewIndex("Age", IndexOptions{Background: true}))
+	index := table.Index("Age")
+
+	// Hold the writers until the build has counted the documents in the
+	// table, so its total is known.
+	start := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	wg.Add(10)
+
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			for j := i; j < 2500; j += 10 {
+				if j%3 == 0 {
+					panicNotNil(table.Delete(fmt.Sprintf("person%04d", j)))
+				} else {
+					panicNotNil(table.Set(fmt.Sprintf("person%04d", j), Person{
+						Age: 100,
+					}))
+				}
+			}
+		}(i)
+	}
+
+	for index.BuildStatus().Total == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(start)
+
+	wg.Wait()
+	panicNotNil(index.WaitForBuild())
+
+	status := index.BuildStatus()
+	if status.Building || status.Err != nil {
+		t.Fatalf("build should have completed, but hasn't: %+v", status)
+	}
+
+	if status.Total != 2500 {
+		t.Fatal("total should be 2500, but is", status.Total)
+	}
+
+	report, err := index.Verify()
+	panicNotNil(err)
+
+	if !report.OK() {
+		t.Fatalf("report should be ok, but isn't: %+v", report)
+	}
+
+	if count := index.CountBetween(100, 100); count != 1666 {
+		t.Fatal("count should be 1666, but is", count)
+	}
+
+	build := index.build
+	index.build = newIndexBuild("")
+
+	_, _, err = index.One(100, nil)
+	if err != ErrIndexBuilding {
+		t.Fatal("error should be ErrIndexBuilding, but is", err)
+	}
+
+	err = index.Between(MinValue, MaxValue).Do(
+		func(key string, counter uint64, doc Document) error {
+			return nil
+		})
+	if err != ErrIndexBuilding {
+		t.Fatal("error should be ErrIndexBuilding, but is", err)
+	}
+
+	if count := index.CountBetween(MinValue, MaxValue); count != 0 {
+		t.Fatal("count should be 0, but is", count)
+	}
+
+	index.build = build
+
+	panicNotNil(table.Set("a", Person{Name: "Duplicate"}))
+	panicNotNil(table.Set("b", Person{Name: "Duplicate"}))
+
+	err = table.NewIndex("Name", IndexOptions{Unique: true})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	if table.Index("Name") != nil {
+		t.Fatal("index should have been dropped, but hasn't")
+	}
+
+	db.Close()
+}
+
+func TestResumeBuild(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("resume_testing"))
+	table := db.Table("resume_testing")
+
+	for i := 0; i < 2000; i++ {
+		panicNotNil(table.Set(fmt.Sprintf("person%04d", i), Person{
+			Age: i % 20,
+		}))
+	}
+
+	// Simulate the database being closed after the first 1200 documents
+	// were indexed.
+	db.config.Tables[0].Indexes = append(db.config.Tables[0].Indexes,
+		indexConfig{
+			IndexName:  "Age",
+			Building:   true,
+			Checkpoint: "person1199",
+			Encoding:   currentEncoding,
+		})
+	panicNotNil(db.writeConfig())
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	index := db.Table("resume_testing").Index("Age")
+	panicNotNil(index.WaitForBuild())
+
+	status := index.BuildStatus()
+	if status.Indexed != 2000 || status.Total != 2000 {
+		t.Fatalf("status should have indexed 2000 of 2000, but hasn't: %+v",
+			status)
+	}
+
+	// Only documents after the checkpoint should have been indexed.
+	if count := index.CountBetween(MinValue, MaxValue); count != 800 {
+		t.Fatal("count should be 800, but is", count)
+	}
+
+	db.configMutex.Lock()
+	config := *db.indexConfig("resume_testing", "Age")
+	db.configMutex.Unlock()
+
+	if config.Building || config.Checkpoint != "" {
+		t.Fatalf("config should not be building, but is: %+v", config)
+	}
+}
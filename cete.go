@@ -5,12 +5,11 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
-	"fmt"
-	"log"
 	"math"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/1lann/badger"
@@ -19,14 +18,51 @@ import (
 
 // Common errors that can be returned
 var (
-	ErrAlreadyExists  = errors.New("cete: already exists")
-	ErrNotFound       = errors.New("cete: not found")
-	ErrBadIdentifier  = errors.New("cete: bad identifier")
-	ErrEndOfRange     = errors.New("cete: end of range")
-	ErrCounterChanged = errors.New("cete: counter changed")
-	ErrIndexError     = errors.New("cete: index error")
+	ErrAlreadyExists          = errors.New("cete: already exists")
+	ErrNotFound               = errors.New("cete: not found")
+	ErrBadIdentifier          = errors.New("cete: bad identifier")
+	ErrEndOfRange             = errors.New("cete: end of range")
+	ErrCounterChanged         = errors.New("cete: counter changed")
+	ErrIndexError             = errors.New("cete: index error")
+	ErrUniqueViolation        = errors.New("cete: unique index violation")
+	ErrIndexBuilding          = errors.New("cete: index is still being built")
+	ErrIndexFuncNotRegistered = errors.New("cete: index function is not " +
+		"registered")
+	ErrUnsupportedValue = errors.New("cete: unsupported index value")
+	ErrCorruptIndex     = errors.New("cete: corrupt index")
+	ErrReadOnly         = errors.New("cete: database is read-only")
 )
 
+// IndexError represents an error which occurred while reading or updating
+// an index. Key is the key of the document being indexed, and is empty if
+// the error isn't specific to a document. IndexError matches ErrIndexError
+// with errors.Is.
+type IndexError struct {
+	Table string
+	Index string
+	Key   string
+	Err   error
+}
+
+func (e *IndexError) Error() string {
+	msg := "cete: index error: " + e.Table + "/" + e.Index
+	if e.Key != "" {
+		msg += ": " + e.Key
+	}
+
+	return msg + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *IndexError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if the target is ErrIndexError.
+func (e *IndexError) Is(target error) bool {
+	return target == ErrIndexError
+}
+
 // Name represents a table or index identifier.
 type Name string
 
@@ -37,8 +73,22 @@ func (n Name) Hex() string {
 
 // Index represents an index of a table.
 type Index struct {
-	index *badger.KV
-	table *Table
+	index  *badger.KV
+	table  *Table
+	query  string
+	unique bool
+	lock   *sync.RWMutex
+	build  *indexBuild
+
+	function bool
+	fn       IndexFunc
+	filtered bool
+	filter   func(doc Document) bool
+	stale    bool
+	collator *collator
+	encoding int32
+
+	migration *indexMigration
 }
 
 // Table represents a table in the database.
@@ -47,6 +97,18 @@ type Table struct {
 	data    *badger.KV
 	db      *DB
 
+	uniqueLock *sync.Mutex
+
+	defaultTTL int64
+	dropped    int32
+
+	watchLock *sync.Mutex
+	watchers  map[*watcher]bool
+
+	// keyLocks serialize writes to the same document, so that their events
+	// are sent in the order they were made.
+	keyLocks []*sync.Mutex
+
 	compressionLock *sync.RWMutex
 	keyToCompressed map[string]string
 	compressedToKey map[string]string
@@ -61,6 +123,18 @@ type DB struct {
 	configMutex *sync.Mutex
 	openOptions badger.Options
 	closed      int32
+	readOnly    bool
+
+	// done is closed when the database is closed, to stop its background
+	// goroutines.
+	done chan struct{}
+
+	logger atomic.Value
+
+	commitLock *sync.RWMutex
+	journal    *badger.KV
+	journalSeq uint64
+	retired    []retiredKV
 }
 
 func exists(path string) (bool, error) {
@@ -82,20 +156,24 @@ func integerToBytes(integer interface{}) []byte {
 	switch i := integer.(type) {
 	case int:
 		num = uint64(i) + (1 << 63)
+	case int8:
+		num = uint64(i) + (1 << 63)
 	case int16:
 		num = uint64(i) + (1 << 63)
 	case int32:
 		num = uint64(i) + (1 << 63)
 	case int64:
 		num = uint64(i) + (1 << 63)
+	case uint:
+		num = uint64(i) + (1 << 63)
+	case uint8:
+		num = uint64(i) + (1 << 63)
 	case uint16:
 		num = uint64(i) + (1 << 63)
 	case uint32:
 		num = uint64(i) + (1 << 63)
 	case uint64:
 		num = i + (1 << 63)
-	default:
-		log.Fatal("integerToBytes called on a non integer: ", i)
 	}
 
 	result := make([]byte, 8)
@@ -103,31 +181,78 @@ func integerToBytes(integer interface{}) []byte {
 	return result
 }
 
-func valueToBytes(value interface{}) (b []byte) {
+// valueToBytes converts a value into an index value, with the default
+// collation and the current encoding. nil is returned if the value isn't
+// supported.
+func valueToBytes(value interface{}) []byte {
+	result, _ := encodeValue(value, lowerString, currentEncoding)
+	return result
+}
+
+func lowerString(s string) []byte {
+	return []byte(strings.ToLower(s))
+}
+
+// encodeValue converts a value into an index value with the given index
+// encoding, using encodeString to convert strings. Times are encoded by
+// the instant they represent, so the same time in different time zones has
+// the same index value. ErrUnsupportedValue is returned if the value, or
+// any of the values in a slice, can't be indexed.
+func encodeValue(value interface{}, encodeString func(string) []byte,
+	encoding int32) ([]byte, error) {
 	switch v := value.(type) {
-	case int, int16, int32, int64, uint16, uint32, uint64:
-		return integerToBytes(v)
-	case float32:
-		return integerToBytes(math.Float32bits(v))
-	case float64:
-		return integerToBytes(math.Float64bits(v))
 	case string:
-		return append([]byte(strings.ToLower(v)), 0)
+		return append(encodeString(v), 0), nil
 	case []byte:
-		return append(v, 0)
+		return append(v, 0), nil
 	case []interface{}:
 		var result []byte
 		for _, vv := range v {
-			result = append(result, valueToBytes(vv)...)
+			b, err := encodeValue(vv, encodeString, encoding)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, b...)
 		}
-		return result
+		return result, nil
+	case *time.Time:
+		if v == nil {
+			return encodeValue(nil, encodeString, encoding)
+		}
+		return encodeValue(*v, encodeString, encoding)
 	case time.Time:
-		return append(valueToBytes(v.Unix()), valueToBytes(v.Nanosecond())...)
+		if encoding != legacyEncoding {
+			return timeToBytes(v), nil
+		}
+
+		seconds, _ := encodeValue(v.Unix(), encodeString, encoding)
+		nanoseconds, _ := encodeValue(v.Nanosecond(), encodeString, encoding)
+		return append(seconds, nanoseconds...), nil
+	}
+
+	if encoding == legacyEncoding {
+		return legacyNumberToBytes(value)
+	}
+
+	return numberToBytes(value)
+}
+
+// legacyNumberToBytes converts a number into an index value with the legacy
+// encoding, which doesn't preserve the order of negative floats, or uint64s
+// above the maximum int64.
+func legacyNumberToBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return integerToBytes(v), nil
+	case float32:
+		return integerToBytes(math.Float32bits(v)), nil
+	case float64:
+		return integerToBytes(math.Float64bits(v)), nil
 	case Bounds:
-		return integerToBytes(int64(v))
+		return integerToBytes(int64(v)), nil
 	}
 
-	panic(fmt.Sprintf("cete: unsupported value: %v", value))
+	return nil, ErrUnsupportedValue
 }
 
 func getItemValue(item *badger.KVItem) []byte {
@@ -143,7 +268,13 @@ func getItemValue(item *badger.KVItem) []byte {
 	if err != nil {
 		return nil
 	}
-	return <-result
+
+	value := <-result
+	if item.UserMeta()&metaExpires != 0 && len(value) >= 8 {
+		return value[8:]
+	}
+
+	return value
 }
 
 // Document represents the value of a document.
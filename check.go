@@ -0,0 +1,329 @@
+package cete
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/1lann/badger"
+	"github.com/1lann/msgpack"
+)
+
+// IndexReport represents the result of verifying an index against the
+// documents in its table.
+type IndexReport struct {
+	Table string
+	Index string
+
+	// DanglingKeys are the keys of documents which are in the index, but
+	// either don't exist or don't have the index value they're listed under.
+	DanglingKeys []string
+	// MissingKeys are the keys of documents which have an index value, but
+	// aren't in the index under that value.
+	MissingKeys []string
+	// MalformedValues is the number of index values whose list of documents
+	// could not be decoded, is empty, or contains duplicates.
+	MalformedValues int
+}
+
+// OK returns true if no problems were found with the index.
+func (r IndexReport) OK() bool {
+	return len(r.DanglingKeys) == 0 && len(r.MissingKeys) == 0 &&
+		r.MalformedValues == 0
+}
+
+type retiredKV struct {
+	kv  *badger.KV
+	dir string
+}
+
+type indexEntry struct {
+	indexKey string
+	key      string
+}
+
+// Verify checks the index against the documents in its table, and returns
+// a report of any inconsistencies found. As the stores don't support
+// snapshots, writes to the database are blocked while the index is being
+// verified, so that it is compared against a consistent table. Use Rebuild to
+// repair an index with inconsistencies.
+func (i *Index) Verify() (IndexReport, error) {
+	i.table.db.commitLock.Lock()
+	defer i.table.db.commitLock.Unlock()
+
+	if i.building() {
+		return IndexReport{}, ErrIndexBuilding
+	}
+
+	return i.verify()
+}
+
+func (i *Index) verify() (IndexReport, error) {
+	name := i.indexName()
+	report := IndexReport{
+		Table: i.table.name(),
+		Index: name,
+	}
+
+	expected := make(map[indexEntry]bool)
+
+	r := i.table.between(context.Background(), MinValue, MaxValue,
+		true)
+	for r.Next() {
+		results, err := i.values(r.Document().data)
+		if err != nil {
+			continue
+		}
+
+		for _, indexKey := range i.indexKeys(results) {
+			expected[indexEntry{string(indexKey), r.Key()}] = true
+		}
+	}
+
+	if r.Error() != ErrEndOfRange {
+		return report, r.Error()
+	}
+
+	found := make(map[indexEntry]bool)
+	dangling := make(map[string]bool)
+
+	itOpts := badger.DefaultIteratorOptions
+	itOpts.PrefetchSize = prefetchSize
+	it := i.index.NewIterator(itOpts)
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		var keys []string
+		err := msgpack.Unmarshal(getItemValue(it.Item()), &keys)
+		if err != nil || len(keys) == 0 {
+			report.MalformedValues++
+			continue
+		}
+
+		indexKey := string(it.Item().Key())
+		duplicate := false
+
+		for _, key := range keys {
+			entry := indexEntry{indexKey, key}
+			if found[entry] {
+				duplicate = true
+			} else if expected[entry] {
+				found[entry] = true
+			} else {
+				dangling[key] = true
+			}
+		}
+
+		if duplicate {
+			report.MalformedValues++
+		}
+	}
+
+	it.Close()
+
+	missing := make(map[string]bool)
+	for entry := range expected {
+		if !found[entry] {
+			missing[entry.key] = true
+		}
+	}
+
+	report.DanglingKeys = sortedKeys(dangling)
+	report.MissingKeys = sortedKeys(missing)
+
+	return report, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	var keys []string
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Rebuild regenerates the index from the documents in its table. The index
+// is rebuilt into a new store, and reads continue to be served from the
+// existing index until the new one is complete. Writes to the database are
+// blocked while the index is being rebuilt. ErrIndexBuilding is returned
+// if the index is still being built for the first time.
+func (i *Index) Rebuild() error {
+	db := i.table.db
+	if db.readOnly {
+		return ErrReadOnly
+	}
+
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	return i.rebuild()
+}
+
+// rebuild rebuilds the index. The database's commit lock must be held by the
+// caller.
+func (i *Index) rebuild() error {
+	db := i.table.db
+
+	if i.building() {
+		return ErrIndexBuilding
+	}
+
+	// A migration of the index would use the same generation.
+	i.stopMigration()
+
+	name := i.indexName()
+	tableName := i.table.name()
+
+	db.configMutex.Lock()
+	config := db.indexConfig(tableName, name)
+	if config == nil {
+		db.configMutex.Unlock()
+		return ErrNotFound
+	}
+	generation := config.Generation + 1
+	db.configMutex.Unlock()
+
+	dir := db.indexPath(tableName, name, generation)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	kv, err := db.openKV(dir)
+	if err != nil {
+		return err
+	}
+
+	err = i.withStore(kv).indexValues()
+	if err == nil {
+		err = i.replaceStore(kv, generation)
+	}
+
+	if err != nil {
+		kv.Close()
+		os.RemoveAll(dir)
+		return err
+	}
+
+	return nil
+}
+
+// withStore returns a new index like this one, which uses kv as its store and
+// the current encoding.
+func (i *Index) withStore(kv *badger.KV) *Index {
+	return &Index{
+		index:    kv,
+		table:    i.table,
+		query:    i.query,
+		unique:   i.unique,
+		lock:     new(sync.RWMutex),
+		function: i.function,
+		fn:       i.fn,
+		filtered: i.filtered,
+		filter:   i.filter,
+		collator: i.collator,
+		encoding: currentEncoding,
+	}
+}
+
+// replaceStore replaces the store of the index with kv, a new generation of
+// the index that has been built with the current encoding. The database's
+// commit lock must be held by the caller.
+func (i *Index) replaceStore(kv *badger.KV, generation int) error {
+	db := i.table.db
+	name := i.indexName()
+	tableName := i.table.name()
+
+	db.configMutex.Lock()
+	config := db.indexConfig(tableName, name)
+	if config == nil {
+		db.configMutex.Unlock()
+		return ErrNotFound
+	}
+
+	config.Generation = generation
+	config.Stale = false
+	encoding := config.Encoding
+	config.Encoding = currentEncoding
+	if err := db.writeConfig(); err != nil {
+		config.Generation = generation - 1
+		config.Stale = i.stale
+		config.Encoding = encoding
+		db.configMutex.Unlock()
+		return err
+	}
+	db.configMutex.Unlock()
+
+	// The old store may still be in use by open ranges, so it is only
+	// closed and removed when the database is closed.
+	i.lock.Lock()
+	db.retired = append(db.retired, retiredKV{
+		kv:  i.index,
+		dir: db.indexPath(tableName, name, generation-1),
+	})
+	i.index = kv
+	i.stale = false
+	atomic.StoreInt32(&i.encoding, currentEncoding)
+	i.lock.Unlock()
+
+	return nil
+}
+
+// indexConfig returns a pointer to the configuration of an index. The
+// database's configMutex must be held by the caller.
+func (d *DB) indexConfig(tableName, indexName string) *indexConfig {
+	for i, table := range d.config.Tables {
+		if table.TableName != tableName {
+			continue
+		}
+
+		for j, index := range table.Indexes {
+			if index.IndexName == indexName {
+				return &d.config.Tables[i].Indexes[j]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check verifies every index of every table in the database, and returns
+// their reports. Indexes which are still being built are skipped. Like
+// with Verify, writes are blocked while each index is being verified, but
+// they can continue in between indexes.
+func (d *DB) Check() ([]IndexReport, error) {
+	var reports []IndexReport
+
+	tableNames := d.Tables()
+	sort.Strings(tableNames)
+
+	for _, tableName := range tableNames {
+		table := d.Table(tableName)
+		if table == nil {
+			continue
+		}
+
+		indexNames := table.Indexes()
+		sort.Strings(indexNames)
+
+		for _, indexName := range indexNames {
+			index := table.Index(indexName)
+			if index == nil {
+				continue
+			}
+
+			report, err := index.Verify()
+			if err == ErrIndexBuilding {
+				continue
+			} else if err != nil {
+				return reports, err
+			}
+
+			reports = append(reports, report)
+		}
+	}
+
+	return reports, nil
+}
@@ -0,0 +1,120 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestVerifyAndRebuild(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("check_testing"))
+	table := db.Table("check_testing")
+	panicNotNil(table.NewIndex("Age"))
+	panicNotNil(table.NewIndex("City"))
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", City: "Sydney", Age: 18}))
+	panicNotNil(table.Set("ben", Person{Name: "Ben", City: "Melbourne", Age: 19}))
+	panicNotNil(table.Set("drew", Person{Name: "Drew", City: "London", Age: 18}))
+
+	reports, err := db.Check()
+	panicNotNil(err)
+
+	if len(reports) != 2 {
+		t.Fatal("there should be 2 reports, but there are", len(reports))
+	}
+
+	for _, report := range reports {
+		if !report.OK() {
+			t.Fatalf("report should be ok, but isn't: %+v", report)
+		}
+	}
+
+	index := table.Index("Age")
+	panicNotNil(index.addToIndex(valueToBytes(int64(18)), "ghost"))
+	panicNotNil(index.deleteFromIndex(valueToBytes(int64(19)), "ben"))
+	panicNotNil(index.index.Set(valueToBytes(int64(30)), []byte{0xc1}, 0))
+
+	report, err := index.Verify()
+	panicNotNil(err)
+
+	if report.OK() {
+		t.Fatal("report should not be ok, but is")
+	}
+
+	if len(report.DanglingKeys) != 1 || report.DanglingKeys[0] != "ghost" {
+		t.Fatal("dangling keys should be [ghost], but are", report.DanglingKeys)
+	}
+
+	if len(report.MissingKeys) != 1 || report.MissingKeys[0] != "ben" {
+		t.Fatal("missing keys should be [ben], but are", report.MissingKeys)
+	}
+
+	if report.MalformedValues != 1 {
+		t.Fatal("malformed values should be 1, but is", report.MalformedValues)
+	}
+
+	r := index.Between(18, 18)
+	panicNotNil(index.Rebuild())
+
+	// The range from before the rebuild can still be read.
+	if _, err = r.Count(); err != nil {
+		t.Fatal("range should still be readable, but isn't:", err)
+	}
+
+	report, err = index.Verify()
+	panicNotNil(err)
+
+	if !report.OK() {
+		t.Fatalf("report should be ok, but isn't: %+v", report)
+	}
+
+	if count := index.CountBetween(MinValue, MaxValue); count != 3 {
+		t.Fatal("count should be 3, but is", count)
+	}
+
+	panicNotNil(table.Set("ben", Person{Name: "Ben", City: "Melbourne", Age: 20}))
+
+	db.Close()
+
+	if found, _ := exists(db.indexPath("check_testing", "Age", 0)); found {
+		t.Fatal("old index should have been removed, but hasn't")
+	}
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	reports, err = db.Check()
+	panicNotNil(err)
+
+	for _, report := range reports {
+		if !report.OK() {
+			t.Fatalf("report should be ok, but isn't: %+v", report)
+		}
+	}
+
+	key, _, err := db.Table("check_testing").Index("Age").One(20, nil)
+	panicNotNil(err)
+
+	if key != "ben" {
+		t.Fatal("key should be ben, but is", key)
+	}
+}
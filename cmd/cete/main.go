@@ -0,0 +1,315 @@
+// Command cete inspects cete databases. Documents are printed as JSON, with
+// their keys decompressed.
+//
+// The database is opened with cete.OpenReadOnly, so its documents and
+// indexes aren't changed, but writes which were interrupted the last time it
+// was used aren't finished either. Badger has no read-only mode, so its own
+// files may still be written to when the database is closed, and the
+// database must not be open by another process.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/1lann/cete"
+)
+
+const usage = `usage: cete -db <path> [flags] <command> [arguments]
+
+Commands:
+  tables                                  list tables and their indexes
+  get <table> <key>                       print a document
+  scan <table> [lower [upper]]            print documents with keys in range
+  index <table> <index> [lower [upper]]   print documents with index values
+                                          in range
+  count <table> [index [lower [upper]]]   count documents
+  dump <table>                            print all documents in a table
+
+Bounds are inclusive, and a bound of "-" or an omitted bound is unbounded.
+Documents are printed as JSON lines of their key, counter and document.
+
+Flags:
+`
+
+type line struct {
+	Key      string        `json:"key"`
+	Counter  uint64        `json:"counter"`
+	Document cete.Document `json:"document"`
+}
+
+var (
+	dbPath    = flag.String("db", "", "path to the database")
+	reverse   = flag.Bool("reverse", false, "print ranges in reverse order")
+	limit     = flag.Int64("limit", 0, "maximum number of documents to print")
+	valueType = flag.String("type", "string",
+		"type of index values: string, int or float")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *dbPath == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		fmt.Fprintln(os.Stderr, "cete:", err)
+		os.Exit(1)
+	}
+
+	db, err := cete.OpenReadOnly(*dbPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	err = run(os.Stdout, db, flag.Arg(0), flag.Args()[1:])
+	db.Close()
+
+	if err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("cete: invalid usage")
+
+func run(w io.Writer, db *cete.DB, command string, args []string) error {
+	switch command {
+	case "tables":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return listTables(w, db)
+	case "get":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return get(w, db, args[0], args[1])
+	case "scan":
+		if len(args) < 1 || len(args) > 3 {
+			return errUsage
+		}
+		return scan(w, db, args[0], args[1:])
+	case "index":
+		if len(args) < 2 || len(args) > 4 {
+			return errUsage
+		}
+		return scanIndex(w, db, args[0], args[1], args[2:])
+	case "count":
+		if len(args) < 1 || len(args) > 4 {
+			return errUsage
+		}
+		return count(w, db, args[0], args[1:])
+	case "dump":
+		if len(args) != 1 {
+			return errUsage
+		}
+
+		table, err := getTable(db, args[0])
+		if err != nil {
+			return err
+		}
+		return table.Export(w)
+	}
+
+	return errUsage
+}
+
+func getTable(db *cete.DB, name string) (*cete.Table, error) {
+	table := db.Table(name)
+	if table == nil {
+		return nil, errors.New("cete: table not found: " + name)
+	}
+
+	return table, nil
+}
+
+func getIndex(db *cete.DB, tableName, name string) (*cete.Index, error) {
+	table, err := getTable(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	index := table.Index(name)
+	if index == nil {
+		return nil, errors.New("cete: index not found: " + name)
+	}
+
+	return index, nil
+}
+
+func listTables(w io.Writer, db *cete.DB) error {
+	tables := db.Tables()
+	sort.Strings(tables)
+
+	for _, tableName := range tables {
+		table := db.Table(tableName)
+		fmt.Fprintf(w, "%s (%d documents)\n", tableName,
+			table.CountBetween(cete.MinValue, cete.MaxValue))
+
+		indexes := table.Indexes()
+		sort.Strings(indexes)
+
+		for _, indexName := range indexes {
+			status := table.Index(indexName).BuildStatus()
+			if status.Building {
+				fmt.Fprintf(w, "  %s (building, %d of %d indexed)\n", indexName,
+					status.Indexed, status.Total)
+			} else {
+				fmt.Fprintln(w, "  "+indexName)
+			}
+		}
+	}
+
+	return nil
+}
+
+func get(w io.Writer, db *cete.DB, tableName, key string) error {
+	table, err := getTable(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	var doc cete.Document
+	counter, err := table.Get(key, &doc)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(line{key, counter, doc})
+}
+
+// bounds returns the lower and upper bounds given as arguments, parsed
+// with the given function.
+func bounds(args []string, parse func(string) (interface{}, error)) (
+	interface{}, interface{}, error) {
+	var lower, upper interface{} = cete.MinValue, cete.MaxValue
+	var err error
+
+	if len(args) > 0 && args[0] != "-" {
+		if lower, err = parse(args[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(args) > 1 && args[1] != "-" {
+		if upper, err = parse(args[1]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return lower, upper, nil
+}
+
+func parseKey(arg string) (interface{}, error) {
+	return arg, nil
+}
+
+func parseValue(arg string) (interface{}, error) {
+	switch *valueType {
+	case "string":
+		return arg, nil
+	case "int":
+		return strconv.ParseInt(arg, 10, 64)
+	case "float":
+		return strconv.ParseFloat(arg, 64)
+	}
+
+	return nil, errors.New("cete: unknown value type: " + *valueType)
+}
+
+func scan(w io.Writer, db *cete.DB, tableName string, args []string) error {
+	table, err := getTable(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	lower, upper, err := bounds(args, parseKey)
+	if err != nil {
+		return err
+	}
+
+	return printRange(w, table.Between(lower, upper, *reverse))
+}
+
+func scanIndex(w io.Writer, db *cete.DB, tableName, indexName string, args []string) error {
+	index, err := getIndex(db, tableName, indexName)
+	if err != nil {
+		return err
+	}
+
+	lower, upper, err := bounds(args, parseValue)
+	if err != nil {
+		return err
+	}
+
+	return printRange(w, index.Between(lower, upper, *reverse))
+}
+
+func printRange(w io.Writer, r *cete.Range) error {
+	if *limit > 0 {
+		r = r.Limit(*limit)
+	}
+
+	defer r.Close()
+
+	enc := json.NewEncoder(w)
+
+	for r.Next() {
+		err := enc.Encode(line{r.Key(), r.Counter(), r.Document()})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.Error() != cete.ErrEndOfRange {
+		return r.Error()
+	}
+
+	return nil
+}
+
+func count(w io.Writer, db *cete.DB, tableName string, args []string) error {
+	if len(args) == 0 {
+		table, err := getTable(db, tableName)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, table.CountBetween(cete.MinValue, cete.MaxValue))
+		return nil
+	}
+
+	index, err := getIndex(db, tableName, args[0])
+	if err != nil {
+		return err
+	}
+
+	if index.BuildStatus().Building {
+		return cete.ErrIndexBuilding
+	}
+
+	lower, upper, err := bounds(args[1:], parseValue)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, index.CountBetween(lower, upper))
+	return nil
+}
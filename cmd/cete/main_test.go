@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/1lann/cete"
+)
+
+type person struct {
+	Name string
+	Age  int
+}
+
+func readLines(t *testing.T, buf *bytes.Buffer) []line {
+	var lines []line
+	for _, text := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var l struct {
+			Key      string
+			Counter  uint64
+			Document person
+		}
+		if err := json.Unmarshal([]byte(text), &l); err != nil {
+			t.Fatal("failed to decode line:", text, err)
+		}
+
+		lines = append(lines, line{Key: l.Key, Counter: l.Counter})
+	}
+
+	return lines
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cete_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := cete.Open(dir + "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.NewTable("people"); err != nil {
+		t.Fatal(err)
+	}
+
+	table := db.Table("people")
+	for i := 0; i < 500; i++ {
+		err = table.Set(fmt.Sprintf("person%03d", i), person{
+			Name: fmt.Sprint("Person ", i),
+			Age:  i % 50,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db.Close()
+
+	db, err = cete.OpenReadOnly(dir + "/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	var buf bytes.Buffer
+	if err = run(&buf, db, "get", []string{"people", "person042"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(buf.String(), `{"key":"person042","counter":`) ||
+		!strings.Contains(buf.String(), `"Name":"Person 42"`) {
+		t.Fatal("output should be person042, but is", buf.String())
+	}
+
+	err = run(&buf, db, "get", []string{"people", "nobody"})
+	if err != cete.ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	buf.Reset()
+	*reverse = true
+	err = run(&buf, db, "scan", []string{"people", "person100", "person199"})
+	*reverse = false
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readLines(t, &buf)
+	if len(lines) != 100 {
+		t.Fatal("there should be 100 lines, but there are", len(lines))
+	}
+
+	for i, l := range lines {
+		if expected := fmt.Sprintf("person%03d", 199-i); l.Key != expected {
+			t.Fatal("key should be", expected, "but is", l.Key)
+		}
+	}
+
+	buf.Reset()
+	if err = run(&buf, db, "dump", []string{"people"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines = readLines(t, &buf)
+	if len(lines) != 500 {
+		t.Fatal("there should be 500 lines, but there are", len(lines))
+	}
+
+	for i, l := range lines {
+		if expected := fmt.Sprintf("person%03d", i); l.Key != expected {
+			t.Fatal("key should be", expected, "but is", l.Key)
+		}
+	}
+
+	if err = run(&buf, db, "dump", nil); err != errUsage {
+		t.Fatal("error should be errUsage, but is", err)
+	}
+}
@@ -0,0 +1,162 @@
+package cete
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization represents a Unicode normalization form.
+type Normalization int
+
+// Unicode normalization forms.
+const (
+	NoNormalization Normalization = iota
+	NFC
+	NFD
+	NFKC
+	NFKD
+)
+
+// Collation represents how an index compares and orders strings. The zero
+// value is case-insensitive, which lowercases strings with strings.ToLower
+// before they are indexed.
+type Collation struct {
+	// CaseSensitive, if true, indexes strings without changing their case.
+	CaseSensitive bool
+
+	// Normalization is the Unicode normalization form that strings are
+	// normalized to before they are indexed, so that equivalent strings
+	// have the same index value.
+	Normalization Normalization
+
+	// FoldAccents, if true, removes accents and other diacritics from
+	// strings before they are indexed, so "café" and "cafe" have the same
+	// index value.
+	FoldAccents bool
+
+	// Locale, if set, is the BCP 47 language tag such as "de" or "sv"
+	// whose collation rules are used to order strings, instead of ordering
+	// them by their bytes.
+	Locale string
+}
+
+// collator converts strings into index values for a Collation.
+type collator struct {
+	collation Collation
+
+	// collate is only set if the collation has a locale. It is not safe
+	// for concurrent use, so it and buf are protected by mutex.
+	collate *collate.Collator
+	buf     *collate.Buffer
+	mutex   *sync.Mutex
+}
+
+// newCollator returns a collator for the collation, or nil if it is the
+// default collation.
+func newCollator(collation Collation) (*collator, error) {
+	if collation == (Collation{}) {
+		return nil, nil
+	}
+
+	if collation.Normalization < NoNormalization ||
+		collation.Normalization > NFKD {
+		return nil, errors.New("cete: invalid normalization")
+	}
+
+	c := &collator{collation: collation}
+
+	if collation.Locale != "" {
+		tag, err := language.Parse(collation.Locale)
+		if err != nil {
+			return nil, errors.New("cete: invalid locale: " + err.Error())
+		}
+
+		var opts []collate.Option
+		if !collation.CaseSensitive {
+			opts = append(opts, collate.IgnoreCase)
+		}
+		if collation.FoldAccents {
+			opts = append(opts, collate.IgnoreDiacritics)
+		}
+
+		c.collate = collate.New(tag, opts...)
+		c.buf = new(collate.Buffer)
+		c.mutex = new(sync.Mutex)
+	}
+
+	return c, nil
+}
+
+// key returns the index value of a string, without its terminator.
+func (c *collator) key(s string) []byte {
+	if c.collation.FoldAccents {
+		s, _, _ = transform.String(transform.Chain(norm.NFD,
+			runes.Remove(runes.In(unicode.Mn))), s)
+		if c.collation.Normalization == NoNormalization {
+			s = norm.NFC.String(s)
+		}
+	}
+
+	switch c.collation.Normalization {
+	case NFC:
+		s = norm.NFC.String(s)
+	case NFD:
+		s = norm.NFD.String(s)
+	case NFKC:
+		s = norm.NFKC.String(s)
+	case NFKD:
+		s = norm.NFKD.String(s)
+	}
+
+	if c.collate != nil {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		key := c.collate.KeyFromString(c.buf, s)
+		result := make([]byte, len(key))
+		copy(result, key)
+		c.buf.Reset()
+
+		return result
+	}
+
+	if !c.collation.CaseSensitive {
+		s = strings.ToLower(s)
+	}
+
+	return []byte(s)
+}
+
+// valueToBytes converts a value into its index value, using the index's
+// collation for strings and its encoding. ErrUnsupportedValue is returned
+// if the value can't be indexed.
+func (i *Index) valueToBytes(value interface{}) ([]byte, error) {
+	encodeString := lowerString
+	if i.collator != nil {
+		encodeString = i.collator.key
+	}
+
+	return encodeValue(value, encodeString, atomic.LoadInt32(&i.encoding))
+}
+
+// indexKeys converts the values of a document into their index values,
+// skipping any values which can't be indexed.
+func (i *Index) indexKeys(values []interface{}) [][]byte {
+	keys := make([][]byte, 0, len(values))
+	for _, value := range values {
+		if key, err := i.valueToBytes(value); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
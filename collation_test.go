@@ -0,0 +1,121 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCollation(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("collation_testing"))
+	table := db.Table("collation_testing")
+
+	for key, name := range map[string]string{
+		"a": "apple",
+		"b": "Émile",
+		"c": "emile",
+		"d": "Ärger",
+		"e": "zebra",
+		"f": "EMILE",
+	} {
+		panicNotNil(table.Set(key, Person{Name: name}))
+	}
+
+	panicNotNil(table.NewIndex("Name"))
+	panicNotNil(table.NewIndex("sensitive", IndexOptions{
+		Query:     "Name",
+		Collation: Collation{CaseSensitive: true},
+	}))
+	panicNotNil(table.NewIndex("folded", IndexOptions{
+		Query: "Name",
+		Collation: Collation{
+			Normalization: NFC,
+			FoldAccents:   true,
+		},
+	}))
+	panicNotNil(table.NewIndex("german", IndexOptions{
+		Query:     "Name",
+		Collation: Collation{Locale: "de"},
+	}))
+
+	err = table.NewIndex("invalid", IndexOptions{
+		Query:     "Name",
+		Collation: Collation{Locale: "not a locale"},
+	})
+	if err == nil {
+		t.Fatal("error should not be nil for an invalid locale")
+	}
+
+	counts := map[string]int64{
+		"Name":      2,
+		"sensitive": 1,
+		"folded":    3,
+		"german":    2,
+	}
+
+	for indexName, expected := range counts {
+		count := table.Index(indexName).CountBetween("emile", "emile")
+		if count != expected {
+			t.Fatal("index", indexName, "should have", expected,
+				"documents with emile, but has", count)
+		}
+	}
+
+	order := func(indexName string) []string {
+		var keys []string
+		r := table.Index(indexName).All()
+		for r.Next() {
+			keys = append(keys, r.Key())
+		}
+		return keys
+	}
+
+	names := order("german")
+	if names[0] != "a" || names[1] != "d" || names[len(names)-1] != "e" {
+		t.Fatal("german index should sort Ärger after apple and before "+
+			"zebra, but the keys are", names)
+	}
+
+	names = order("Name")
+	if names[len(names)-3] != "e" {
+		t.Fatal("default index should sort Ärger and Émile after zebra, "+
+			"but the keys are", names)
+	}
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("collation_testing")
+
+	if names = order("german"); names[1] != "d" {
+		t.Fatal("german index should keep its collation when reopened, but "+
+			"the keys are", names)
+	}
+
+	if count := table.Index("sensitive").CountBetween("EMILE",
+		"EMILE"); count != 1 {
+		t.Fatal("sensitive index should have 1 document with EMILE, but has",
+			count)
+	}
+}
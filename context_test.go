@@ -0,0 +1,135 @@
+package cete
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForClose waits for the range's goroutine to stop and close its buffer.
+func waitForClose(t *testing.T, r *Range) {
+	done := make(chan struct{})
+	go func() {
+		for range r.entries() {
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for range to close")
+	}
+}
+
+func TestContext(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("context_testing"))
+	table := db.Table("context_testing")
+	panicNotNil(table.NewIndex("Age"))
+
+	for i := 0; i < 500; i++ {
+		panicNotNil(table.Set(fmt.Sprintf("person%03d", i), Person{
+			Age: i % 2,
+		}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := table.BetweenCtx(ctx, MinValue, MaxValue)
+	if !r.Next() || r.Key() != "person000" {
+		t.Fatal("first key should be person000, but is", r.Key())
+	}
+
+	cancel()
+
+	if r.Next() {
+		t.Fatal("next should be false, but is true")
+	}
+
+	if r.Error() != context.Canceled {
+		t.Fatal("error should be context.Canceled, but is", r.Error())
+	}
+
+	waitForClose(t, r)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	r = table.Index("Age").BetweenCtx(ctx, 0, 1)
+	if !r.Next() {
+		t.Fatal("next should be true, but is false")
+	}
+	cancel()
+	waitForClose(t, r)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	r = table.Index("Age").GetAllCtx(ctx, 1)
+	cancel()
+	waitForClose(t, r)
+
+	r = table.Index("Age").GetAllCtx(ctx, 1)
+	if r.Next() || r.Error() != context.Canceled {
+		t.Fatal("error should be context.Canceled, but is", r.Error())
+	}
+
+	var calls int32
+	ctx, cancel = context.WithCancel(context.Background())
+	r = table.BetweenCtx(ctx, MinValue, MaxValue)
+	err = r.DoCtx(ctx, func(key string, counter uint64, doc Document) error {
+		if atomic.AddInt32(&calls, 1) == 10 {
+			cancel()
+		}
+		return nil
+	}, 1)
+	if err != context.Canceled {
+		t.Fatal("error should be context.Canceled, but is", err)
+	}
+	waitForClose(t, r)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	r = table.All().FilterCtx(ctx, func(doc Document) (bool, error) {
+		return doc.QueryInt("Age") == 1, nil
+	})
+	if !r.Next() || r.Key() != "person001" {
+		t.Fatal("first key should be person001, but is", r.Key())
+	}
+
+	cancel()
+
+	if r.Next() {
+		t.Fatal("next should be false, but is true")
+	}
+
+	if r.Error() != context.Canceled {
+		t.Fatal("error should be context.Canceled, but is", r.Error())
+	}
+
+	waitForClose(t, r)
+
+	count, err := table.BetweenCtx(context.Background(), MinValue,
+		MaxValue).Count()
+	panicNotNil(err)
+	if count != 500 {
+		t.Fatal("count should be 500, but is", count)
+	}
+}
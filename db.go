@@ -1,17 +1,38 @@
 package cete
 
-import "sync/atomic"
+import (
+	"os"
+	"sync/atomic"
+)
 
 // Close closes the database (all file handlers to the database).
 func (d *DB) Close() {
-	atomic.StoreInt32(&d.closed, 1)
+	if !atomic.CompareAndSwapInt32(&d.closed, 0, 1) {
+		return
+	}
+
+	close(d.done)
+
+	d.commitLock.Lock()
+	defer d.commitLock.Unlock()
 
 	for _, table := range d.tables {
+		table.stopWatchers()
 		for _, index := range table.indexes {
+			index.stopMigration()
 			index.index.Close()
 		}
 		table.data.Close()
 	}
+
+	if d.journal != nil {
+		d.journal.Close()
+	}
+
+	for _, retired := range d.retired {
+		retired.kv.Close()
+		os.RemoveAll(retired.dir)
+	}
 }
 
 // Tables returns the list of tables in the database.
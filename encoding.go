@@ -0,0 +1,425 @@
+package cete
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// The encodings of index values. Indexes created before numericEncoding use
+// legacyEncoding, and are migrated to the current encoding in the
+// background when the database is opened.
+const (
+	legacyEncoding int32 = iota
+	numericEncoding
+
+	currentEncoding = numericEncoding
+)
+
+// The first byte of numbers, times, bools and nil encoded with
+// numericEncoding, which orders MinValue first, then numbers, times, bools,
+// nil and MaxValue last. legacyEncoding encodes times as two numbers.
+const (
+	tagMinValue byte = 0x00
+	tagNumber   byte = 0x02
+	tagTime     byte = 0x03
+	tagBool     byte = 0x04
+	tagNil      byte = 0x05
+	tagMaxValue byte = 0xff
+)
+
+var (
+	minValueBytes = []byte{tagMinValue, 0, 0, 0, 0, 0, 0, 0, 0}
+	maxValueBytes = []byte{tagMaxValue, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff}
+	nilBytes = []byte{tagNil}
+)
+
+// numberToBytes converts a number, bool or nil into an order preserving
+// index value. Integers and floats of every width share the same encoding,
+// so they can be compared with each other, and integers keep their exact
+// value across the full range of int64 and uint64. Equal numbers, such as 2
+// and 2.0, have the same index value. ErrUnsupportedValue is returned for
+// any other value.
+//
+// Numbers are encoded as their nearest float64, followed by the difference
+// between the number and the float64, which is only non-zero for integers
+// that can't be represented exactly by a float64.
+func numberToBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case int:
+		return signedToBytes(int64(v)), nil
+	case int8:
+		return signedToBytes(int64(v)), nil
+	case int16:
+		return signedToBytes(int64(v)), nil
+	case int32:
+		return signedToBytes(int64(v)), nil
+	case int64:
+		return signedToBytes(v), nil
+	case uint:
+		return unsignedToBytes(uint64(v)), nil
+	case uint8:
+		return unsignedToBytes(uint64(v)), nil
+	case uint16:
+		return unsignedToBytes(uint64(v)), nil
+	case uint32:
+		return unsignedToBytes(uint64(v)), nil
+	case uint64:
+		return unsignedToBytes(v), nil
+	case float32:
+		return numberBytes(float64(v), 0), nil
+	case float64:
+		return numberBytes(v, 0), nil
+	case bool:
+		if v {
+			return []byte{tagBool, 1}, nil
+		}
+		return []byte{tagBool, 0}, nil
+	case nil:
+		return nilBytes, nil
+	case Bounds:
+		if v == MinValue {
+			return minValueBytes, nil
+		} else if v == MaxValue {
+			return maxValueBytes, nil
+		}
+
+		return signedToBytes(int64(v)), nil
+	}
+
+	return nil, ErrUnsupportedValue
+}
+
+func signedToBytes(v int64) []byte {
+	if v >= 0 {
+		return unsignedToBytes(uint64(v))
+	}
+
+	f := float64(v)
+	return numberBytes(f, v-int64(f))
+}
+
+func unsignedToBytes(v uint64) []byte {
+	f := float64(v)
+	if f >= 1<<64 {
+		// The number was rounded up to 2^64, which doesn't fit in a uint64.
+		return numberBytes(f, -int64(-v))
+	}
+
+	return numberBytes(f, int64(v-uint64(f)))
+}
+
+// numberBytes encodes a float64, and the difference between it and the
+// integer it was converted from, which is less than 2^12.
+func numberBytes(f float64, offset int64) []byte {
+	if f == 0 {
+		// Negative zero is equal to zero.
+		f = 0
+	}
+
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	result := make([]byte, 11)
+	result[0] = tagNumber
+	binary.BigEndian.PutUint64(result[1:], bits)
+	binary.BigEndian.PutUint16(result[9:], uint16(offset)^(1<<15))
+	return result
+}
+
+// decodeNumber decodes a number encoded with numberBytes. Whole numbers
+// within the range of an int64 are decoded as int64s, larger whole numbers
+// within the range of a uint64 are decoded as uint64s, and other numbers are
+// decoded as float64s.
+func decodeNumber(f float64, offset int64) interface{} {
+	if f != math.Trunc(f) {
+		return f
+	}
+
+	var u uint64
+	switch {
+	case f >= -(1<<63) && f < 1<<63:
+		return int64(f) + offset
+	case f >= 1<<63 && f < 1<<64:
+		u = uint64(f) + uint64(offset)
+	case f == 1<<64 && offset < 0:
+		u = uint64(offset)
+	default:
+		return f
+	}
+
+	// Integers just below 2^63 are rounded up to it.
+	if u <= math.MaxInt64 {
+		return int64(u)
+	}
+
+	return u
+}
+
+// timeToBytes converts a time into an order preserving index value, which is
+// its Unix time in seconds followed by its nanoseconds.
+func timeToBytes(t time.Time) []byte {
+	result := make([]byte, 13)
+	result[0] = tagTime
+	binary.BigEndian.PutUint64(result[1:], uint64(t.Unix())^(1<<63))
+	binary.BigEndian.PutUint32(result[9:], uint32(t.Nanosecond()))
+	return result
+}
+
+// decodeValues decodes an index value with the current encoding back into
+// the values it was encoded from, or returns false if it is malformed.
+// Strings are decoded as they were indexed, so their original case is lost
+// with the default collation, and byte slices are decoded as strings.
+// Numbers are decoded as described by decodeNumber, and times are decoded in
+// UTC.
+func decodeValues(b []byte) ([]interface{}, bool) {
+	var values []interface{}
+
+	for len(b) > 0 {
+		switch b[0] {
+		case tagNumber:
+			if len(b) < 11 {
+				return nil, false
+			}
+
+			bits := binary.BigEndian.Uint64(b[1:9])
+			if bits&(1<<63) != 0 {
+				bits &^= 1 << 63
+			} else {
+				bits = ^bits
+			}
+
+			offset := int16(binary.BigEndian.Uint16(b[9:11]) ^ (1 << 15))
+			values = append(values, decodeNumber(math.Float64frombits(bits),
+				int64(offset)))
+
+			b = b[11:]
+		case tagTime:
+			if len(b) < 13 {
+				return nil, false
+			}
+
+			seconds := int64(binary.BigEndian.Uint64(b[1:9]) ^ (1 << 63))
+			nanoseconds := int64(binary.BigEndian.Uint32(b[9:13]))
+			values = append(values, time.Unix(seconds, nanoseconds).UTC())
+
+			b = b[13:]
+		case tagBool:
+			if len(b) < 2 {
+				return nil, false
+			}
+
+			values = append(values, b[1] == 1)
+			b = b[2:]
+		case tagNil:
+			values = append(values, nil)
+			b = b[1:]
+		default:
+			end := bytes.IndexByte(b, 0)
+			if end < 0 {
+				return nil, false
+			}
+
+			values = append(values, string(b[:end]))
+			b = b[end+1:]
+		}
+	}
+
+	return values, true
+}
+
+// migrateIndexes marks the indexes which use an older encoding as stale,
+// and migrates them to the current encoding in the background, one at a
+// time, as described by runMigration. Indexes whose function or filter
+// hasn't been registered are rebuilt when it is, and indexes which are being
+// built are rebuilt when their build completes. It is used when the database
+// is opened.
+func (d *DB) migrateIndexes() error {
+	marked := false
+
+	for tableName, table := range d.tables {
+		for indexName, index := range table.indexes {
+			if index.encoding == currentEncoding || index.stale {
+				continue
+			}
+
+			index.stale = true
+			marked = true
+
+			config := d.indexConfig(string(tableName), string(indexName))
+			if config != nil {
+				config.Stale = true
+			}
+		}
+	}
+
+	if marked {
+		if err := d.writeConfig(); err != nil {
+			return err
+		}
+	}
+
+	var stale []*Index
+
+	for _, table := range d.tables {
+		for _, index := range table.indexes {
+			if index.stale && !index.unregistered() && index.build == nil {
+				stale = append(stale, index)
+			}
+		}
+	}
+
+	if len(stale) > 0 {
+		go d.runMigration(stale)
+	}
+
+	return nil
+}
+
+// indexMigration represents the migration of an index to the current
+// encoding. The index is built into target, a store of the next generation of
+// the index, which replaces the index's store once every document has been
+// indexed. It must only be accessed while holding the database's commit lock.
+type indexMigration struct {
+	target     *Index
+	dir        string
+	generation int
+}
+
+// runMigration migrates the stale indexes to the current encoding, logging
+// the progress of the migration. Like an index build, each index is built
+// into a new store in batches, so writes are only blocked while a batch is
+// being indexed, and writes to documents which have already been indexed
+// update the new store. Until its new store is complete, an index continues
+// to be used with its older encoding.
+//
+// The progress of a migration isn't saved, so an index whose migration is
+// interrupted by the database being closed, or which fails to be migrated, is
+// migrated again from the start when the database is next opened.
+func (d *DB) runMigration(indexes []*Index) {
+	for n, index := range indexes {
+		name := index.name()
+
+		started, err := index.startMigration()
+		if started {
+			d.log("cete: migrating index " + name + " to the current encoding (" +
+				strconv.Itoa(n+1) + " of " + strconv.Itoa(len(indexes)) + ")")
+
+			finished := false
+			for !finished && err == nil {
+				finished, err = index.migrateBatch()
+			}
+		}
+
+		if err == errBuildStopped {
+			return
+		} else if err != nil {
+			d.log("cete: failed to migrate "+name+":", err)
+		}
+	}
+}
+
+// startMigration creates the store that the index is migrated into, and
+// returns false if the index no longer needs to be migrated.
+func (i *Index) startMigration() (bool, error) {
+	db := i.table.db
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	if atomic.LoadInt32(&db.closed) != 0 {
+		return false, errBuildStopped
+	}
+
+	// The index may have been rebuilt or dropped since it was marked.
+	name := i.indexName()
+	tableName := i.table.name()
+
+	db.configMutex.Lock()
+	config := db.indexConfig(tableName, name)
+	db.configMutex.Unlock()
+
+	if !i.stale || config == nil || i.table.indexes[Name(name)] != i {
+		return false, nil
+	}
+
+	generation := config.Generation + 1
+	dir := db.indexPath(tableName, name, generation)
+	if err := os.RemoveAll(dir); err != nil {
+		return false, err
+	}
+
+	kv, err := db.openKV(dir)
+	if err != nil {
+		return false, err
+	}
+
+	target := i.withStore(kv)
+	target.build = newIndexBuild("")
+
+	i.migration = &indexMigration{
+		target:     target,
+		dir:        dir,
+		generation: generation,
+	}
+
+	return true, nil
+}
+
+// migrateBatch indexes the next batch of documents into the migration of the
+// index, and replaces the index's store once there are no more documents to
+// index. It returns true if the migration has completed, or has been stopped
+// by the index being rebuilt or dropped.
+func (i *Index) migrateBatch() (bool, error) {
+	db := i.table.db
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	if atomic.LoadInt32(&db.closed) != 0 {
+		return false, errBuildStopped
+	}
+
+	m := i.migration
+	if m == nil {
+		return true, nil
+	}
+
+	checkpoint, n, finished, err := m.target.indexBatch()
+	if err == nil && finished {
+		err = i.replaceStore(m.target.index, m.generation)
+	}
+
+	if err != nil {
+		i.stopMigration()
+		return false, err
+	}
+
+	if finished {
+		i.migration = nil
+		return true, nil
+	}
+
+	m.target.advanceBuild(checkpoint, n, false)
+
+	return false, nil
+}
+
+// stopMigration stops the migration of the index, if there is one, and
+// removes its store. The database's commit lock must be held by the caller.
+func (i *Index) stopMigration() {
+	if i.migration == nil {
+		return
+	}
+
+	i.migration.target.index.Close()
+	os.RemoveAll(i.migration.dir)
+	i.migration = nil
+}
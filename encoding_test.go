@@ -0,0 +1,390 @@
+package cete
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type encodingScore struct {
+	Score interface{}
+}
+
+func TestNumberOrdering(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("encoding_testing"))
+	table := db.Table("encoding_testing")
+	panicNotNil(table.NewIndex("Score"))
+
+	values := map[string]interface{}{
+		"a": -10.5,
+		"b": -1.25,
+		"c": 0.0,
+		"d": 2.5,
+		"e": float32(-3.5),
+		"f": -100,
+		"g": 7,
+		"h": uint64(math.MaxInt64),
+		"i": uint64(math.MaxUint64),
+		"j": int64(1<<53 + 1),
+		"k": float64(1 << 53),
+		"l": uint64(1<<63 + 1),
+		"m": float64(1 << 63),
+	}
+
+	for key, value := range values {
+		panicNotNil(table.Set(key, encodingScore{Score: value}))
+	}
+
+	keys := func(r *Range) []string {
+		var results []string
+		for r.Next() {
+			results = append(results, r.Key())
+		}
+		return results
+	}
+
+	index := table.Index("Score")
+
+	expectKeys := func(r *Range, expected ...string) {
+		t.Helper()
+
+		results := keys(r)
+		if len(results) != len(expected) {
+			t.Fatal("keys should be", expected, "but are", results)
+		}
+
+		for i := range results {
+			if results[i] != expected[i] {
+				t.Fatal("keys should be", expected, "but are", results)
+			}
+		}
+	}
+
+	expectKeys(index.Between(-5.0, 5.0), "e", "b", "c", "d")
+	expectKeys(index.Between(float32(-20), float32(-1)), "a", "e", "b")
+	expectKeys(index.Between(uint64(math.MaxInt64), MaxValue), "h", "m",
+		"l", "i")
+	expectKeys(index.Between(MinValue, 0), "f", "a", "e", "b", "c")
+	expectKeys(index.Between(int64(math.MinInt64), uint64(math.MaxUint64)),
+		"f", "a", "e", "b", "c", "d", "g", "k", "j", "h", "m", "l", "i")
+	expectKeys(index.Between(-5.0, 5.0, true), "d", "c", "b", "e")
+
+	// Integer bounds should compare with floats by their value.
+	expectKeys(index.Between(-1000, 1000), "f", "a", "e", "b", "c", "d", "g")
+	expectKeys(index.Between(-11, 3), "a", "e", "b", "c", "d")
+	expectKeys(index.Between(uint8(3), int8(100)), "g")
+	expectKeys(index.Between(0, 0.0), "c")
+
+	// Integers which can't be represented exactly by a float64 should keep
+	// their order.
+	expectKeys(index.Between(int64(1<<53), uint64(1<<53+1)), "k", "j")
+	expectKeys(index.Between(float64(1<<63), float64(1<<63)), "m")
+	expectKeys(index.Between(int64(math.MaxInt64), uint64(1<<63+1)), "h",
+		"m", "l")
+
+	panicNotNil(db.NewTable("heights_testing"))
+	heights := db.Table("heights_testing")
+	panicNotNil(heights.NewIndex("Height"))
+
+	panicNotNil(heights.Set("ben", Person{Height: 180.5}))
+	panicNotNil(heights.Set("drew", Person{Height: 165.25}))
+	panicNotNil(heights.Set("jason", Person{Height: 190}))
+
+	expectKeys(heights.Index("Height").Between(170, 190), "ben", "jason")
+	expectKeys(heights.Index("Height").Between(MinValue, 180), "drew")
+}
+
+func TestEncodingMigration(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("migration_testing"))
+	table := db.Table("migration_testing")
+	panicNotNil(table.NewIndex("Score"))
+
+	// Simulate an index created before the current encoding.
+	atomic.StoreInt32(&table.Index("Score").encoding, legacyEncoding)
+	db.indexConfig("migration_testing", "Score").Encoding = legacyEncoding
+	panicNotNil(db.writeConfig())
+
+	panicNotNil(table.Set("a", encodingScore{Score: -2.0}))
+	panicNotNil(table.Set("b", encodingScore{Score: 1.0}))
+	panicNotNil(table.Set("c", encodingScore{Score: 3.0}))
+
+	if count := table.Index("Score").CountBetween(-5.0, 5.0); count == 3 {
+		t.Fatal("legacy encoding should not order negative floats")
+	}
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("migration_testing")
+	index := table.Index("Score")
+
+	// The index is migrated in the background.
+	for i := 0; atomic.LoadInt32(&index.encoding) != currentEncoding; i++ {
+		if i > 500 {
+			t.Fatal("index should be migrated to the current encoding")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	db.commitLock.RLock()
+	stale := index.stale
+	db.commitLock.RUnlock()
+
+	if stale {
+		t.Fatal("index should not be stale after it is migrated")
+	}
+
+	db.configMutex.Lock()
+	config := db.indexConfig("migration_testing", "Score")
+	db.configMutex.Unlock()
+	if config.Encoding != currentEncoding || config.Stale {
+		t.Fatalf("index configuration should be migrated, but is %+v",
+			*config)
+	}
+
+	if count := index.CountBetween(-5.0, 5.0); count != 3 {
+		t.Fatal("count should be 3, but is", count)
+	}
+
+	report, err := index.Verify()
+	panicNotNil(err)
+	if !report.OK() {
+		t.Fatalf("index should be consistent, but has %+v", report)
+	}
+}
+
+func TestEncodingMigrationWrites(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("migration_testing"))
+	table := db.Table("migration_testing")
+	panicNotNil(table.NewIndex("Score"))
+	index := table.Index("Score")
+
+	panicNotNil(table.Set("a", encodingScore{Score: -2.0}))
+	panicNotNil(table.Set("b", encodingScore{Score: 1.0}))
+	panicNotNil(table.Set("c", encodingScore{Score: 3.0}))
+
+	db.commitLock.Lock()
+	index.stale = true
+	db.commitLock.Unlock()
+
+	started, err := index.startMigration()
+	panicNotNil(err)
+	if !started {
+		t.Fatal("migration should have started")
+	}
+
+	// Index the first batch, as if there were more documents to index.
+	db.commitLock.Lock()
+	target := index.migration.target
+	checkpoint, n, _, err := target.indexBatch()
+	panicNotNil(err)
+	target.advanceBuild(checkpoint, n, false)
+	db.commitLock.Unlock()
+
+	// Writes to documents which have been migrated update the new store.
+	panicNotNil(table.Set("b", encodingScore{Score: 4.0}))
+	panicNotNil(table.Delete("a"))
+	panicNotNil(table.Set("d", encodingScore{Score: -1.0}))
+
+	if count := index.CountBetween(-5.0, 5.0); count != 3 {
+		t.Fatal("count should be 3 before the migration completes, but is",
+			count)
+	}
+
+	finished, err := index.migrateBatch()
+	panicNotNil(err)
+	if !finished {
+		t.Fatal("migration should have finished")
+	}
+
+	if index.stale || index.migration != nil {
+		t.Fatal("index should not be stale after it is migrated")
+	}
+
+	report, err := index.Verify()
+	panicNotNil(err)
+	if !report.OK() {
+		t.Fatalf("index should be consistent, but has %+v", report)
+	}
+
+	var keys []string
+	r := index.Between(-5.0, 5.0)
+	for r.Next() {
+		keys = append(keys, r.Key())
+	}
+
+	if len(keys) != 3 || keys[0] != "d" || keys[1] != "c" || keys[2] != "b" {
+		t.Fatal("keys should be [d c b], but are", keys)
+	}
+}
+
+func TestValueTypes(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("types_testing"))
+	table := db.Table("types_testing")
+	panicNotNil(table.NewIndex("Score"))
+
+	values := map[string]interface{}{
+		"bool":     true,
+		"false":    false,
+		"int8":     int8(-3),
+		"uint8":    uint8(200),
+		"uint":     uint(5),
+		"nil":      nil,
+		"nested":   []interface{}{1, []interface{}{"a", true}},
+		"negative": -1000,
+	}
+
+	for key, value := range values {
+		panicNotNil(table.Set(key, encodingScore{Score: value}))
+	}
+
+	err = table.Set("map", encodingScore{Score: map[string]interface{}{"a": 1}})
+	var indexErr *IndexError
+	if !errors.As(err, &indexErr) || !errors.Is(err, ErrUnsupportedValue) ||
+		indexErr.Key != "map" || indexErr.Index != "Score" {
+		t.Fatal("error should be an IndexError wrapping ErrUnsupportedValue, "+
+			"but is", err)
+	}
+
+	if _, err = table.Get("map", nil); err != nil {
+		t.Fatal("map should have been set, but wasn't:", err)
+	}
+
+	expect := func(value interface{}, expected string) {
+		t.Helper()
+
+		key, _, err := table.Index("Score").One(value, nil)
+		panicNotNil(err)
+		if key != expected {
+			t.Fatal("key for", value, "should be", expected, "but is", key)
+		}
+	}
+
+	expect(true, "bool")
+	expect(false, "false")
+	expect(-3, "int8")
+	expect(uint64(200), "uint8")
+	expect(int8(5), "uint")
+	expect(nil, "nil")
+	expect([]interface{}{1, []interface{}{"a", true}}, "nested")
+
+	var keys []string
+	r := table.Index("Score").Between(int8(-5), uint8(250))
+	for r.Next() {
+		keys = append(keys, r.Key())
+	}
+
+	if len(keys) != 4 || keys[0] != "int8" || keys[1] != "nested" ||
+		keys[2] != "uint" || keys[3] != "uint8" {
+		t.Fatal("keys should be [int8 nested uint uint8], but are", keys)
+	}
+
+	r = table.Index("Score").Between(map[string]int{}, MaxValue)
+	if r.Next() || r.Error() != ErrUnsupportedValue {
+		t.Fatal("error should be ErrUnsupportedValue, but is", r.Error())
+	}
+
+	_, _, err = table.Index("Score").One(struct{}{}, nil)
+	if err != ErrUnsupportedValue {
+		t.Fatal("error should be ErrUnsupportedValue, but is", err)
+	}
+
+	report, err := table.Index("Score").Verify()
+	panicNotNil(err)
+	if !report.OK() {
+		t.Fatalf("index should be consistent, but has %+v", report)
+	}
+
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	panicNotNil(err)
+
+	now := time.Now()
+	panicNotNil(table.Set("utc", encodingScore{Score: now.UTC()}))
+	panicNotNil(table.Set("sydney", encodingScore{Score: now.In(sydney)}))
+
+	if count := table.Index("Score").CountBetween(now, now); count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+}
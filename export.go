@@ -0,0 +1,168 @@
+package cete
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// exportLine represents a document in the JSON Lines format used by Export
+// and Import.
+type exportLine struct {
+	Key      string      `json:"key"`
+	Counter  uint64      `json:"counter,omitempty"`
+	Document interface{} `json:"document"`
+}
+
+// Export writes every document in the table to w in the JSON Lines format,
+// sorted by key. Each line is a JSON object with the document's "key",
+// "counter" and "document". As JSON has no time or binary types, times are
+// written as {"$time": "<RFC 3339 time>"} and byte slices are written as
+// {"$bytes": "<base64>"}, which Import converts back.
+func (t *Table) Export(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	r := t.All()
+	defer r.Close()
+
+	for r.Next() {
+		err := enc.Encode(exportLine{
+			Key:      r.Key(),
+			Counter:  r.Counter(),
+			Document: r.Document(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.Error() != ErrEndOfRange {
+		return r.Error()
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the document as JSON, in the same format as the
+// documents written by Table.Export.
+func (v Document) MarshalJSON() ([]byte, error) {
+	var value interface{}
+	if err := v.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jsonValue(value))
+}
+
+// jsonValue converts a value decoded from a document into a value that can
+// be encoded as JSON.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{})
+		for key, value := range v {
+			result[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return result
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = jsonValue(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	case []byte:
+		return map[string]string{
+			"$bytes": base64.StdEncoding.EncodeToString(v),
+		}
+	case time.Time:
+		return map[string]string{"$time": v.Format(time.RFC3339Nano)}
+	case *time.Time:
+		return map[string]string{"$time": v.Format(time.RFC3339Nano)}
+	}
+
+	return value
+}
+
+// Import reads documents in the JSON Lines format written by Export from r,
+// and sets them in the table, updating its indexes. The counter of each
+// document is ignored. Integers are imported as int64s, and all other
+// numbers as float64s. Import stops at the first document that fails to be
+// set, such as one which violates a unique index, and returns the error.
+// Documents set before the error are not removed. Documents with values that
+// can't be indexed are still imported, and the last IndexError wrapping
+// ErrUnsupportedValue is returned once all of the documents are imported.
+func (t *Table) Import(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var skipped error
+
+	for line := 1; ; line++ {
+		var entry exportLine
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return skipped
+		} else if err != nil {
+			return errors.New("cete: failed to read line " +
+				strconv.Itoa(line) + ": " + err.Error())
+		}
+
+		if entry.Key == "" {
+			return errors.New("cete: missing key on line " +
+				strconv.Itoa(line))
+		}
+
+		err = t.Set(entry.Key, documentValue(entry.Document))
+		if errors.Is(err, ErrUnsupportedValue) {
+			skipped = err
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// documentValue converts a value decoded from JSON into a value to be stored
+// in a document.
+func documentValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if str, ok := v["$bytes"].(string); ok {
+				if b, err := base64.StdEncoding.DecodeString(str); err == nil {
+					return b
+				}
+			} else if str, ok := v["$time"].(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, str); err == nil {
+					return t
+				}
+			}
+		}
+
+		for key, value := range v {
+			v[key] = documentValue(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = documentValue(value)
+		}
+		return v
+	}
+
+	return value
+}
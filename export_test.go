@@ -0,0 +1,147 @@
+package cete
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("export_testing"))
+	panicNotNil(db.NewTable("import_testing", false))
+
+	people := map[string]Person{
+		"jason": {
+			Name:   "Jason",
+			City:   "Sydney",
+			Age:    19,
+			Height: 180.5,
+			Likes:  []string{"go", "badger"},
+			DOB:    time.Date(1999, 3, 2, 1, 0, 0, 0, time.UTC),
+			Data:   []byte{1, 2, 3},
+		},
+		"ben": {Name: "Ben", Age: 20},
+	}
+
+	table := db.Table("export_testing")
+	for key, person := range people {
+		panicNotNil(table.Set(key, person))
+	}
+
+	var buf bytes.Buffer
+	panicNotNil(table.Export(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("there should be 2 lines, but there are", len(lines))
+	}
+
+	if !strings.HasPrefix(lines[0], `{"key":"ben","counter":`) ||
+		!strings.Contains(lines[0], `"Name":"Ben"`) {
+		t.Fatal("line should be a document of ben, but is", lines[0])
+	}
+
+	imported := db.Table("import_testing")
+	panicNotNil(imported.NewIndex("Age"))
+	panicNotNil(imported.Import(&buf))
+
+	for key, expected := range people {
+		var person Person
+		_, err = imported.Get(key, &person)
+		panicNotNil(err)
+
+		if !person.IsSame(expected) || person.Height != expected.Height ||
+			len(person.Likes) != len(expected.Likes) ||
+			!person.DOB.Equal(expected.DOB) ||
+			!bytes.Equal(person.Data, expected.Data) {
+			t.Fatalf("person should be %+v, but is %+v", expected, person)
+		}
+	}
+
+	var person Person
+	_, _, err = imported.Index("Age").One(19, &person)
+	panicNotNil(err)
+	if person.Name != "Jason" {
+		t.Fatal("name should be Jason, but is", person.Name)
+	}
+
+	err = imported.Import(strings.NewReader(`{"key":"drew","document":{}}
+{"document":{"Name":"Nobody"}}`))
+	if err == nil || err.Error() != "cete: missing key on line 2" {
+		t.Fatal("error should be a missing key on line 2, but is", err)
+	}
+
+	_, err = imported.Get("drew", nil)
+	panicNotNil(err)
+}
+
+func TestExportOrder(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("export_order_testing"))
+	table := db.Table("export_order_testing")
+
+	for i := 0; i < 2000; i++ {
+		panicNotNil(table.Set(paddedItoa(i), Person{Age: i}))
+	}
+
+	var buf bytes.Buffer
+	panicNotNil(table.Export(&buf))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2000 {
+		t.Fatal("there should be 2000 lines, but there are", len(lines))
+	}
+
+	previous := ""
+	for _, line := range lines {
+		var entry exportLine
+		panicNotNil(json.Unmarshal([]byte(line), &entry))
+
+		if entry.Key <= previous {
+			t.Fatal("key", entry.Key, "should be after", previous)
+		}
+
+		previous = entry.Key
+	}
+}
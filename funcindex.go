@@ -0,0 +1,143 @@
+package cete
+
+import "errors"
+
+// IndexFunc returns the index values of a document, for indexes created with
+// NewFuncIndex. Like the values returned by a query, each value is indexed
+// separately, and a slice of values is indexed as a compound value. If an
+// error is returned, or no values are returned, the document is not indexed.
+type IndexFunc func(doc Document) ([]interface{}, error)
+
+// NewFuncIndex creates a new index on the table, whose values are computed
+// from each document with fn instead of a query. It behaves like NewIndex,
+// and the Query of the IndexOptions is ignored.
+//
+// As functions can't be stored in the database, NewFuncIndex must be called
+// again with the function each time the database is opened. If the index
+// already exists and its function hasn't been registered, it is registered
+// and nil is returned, ignoring the IndexOptions other than Background and
+// Filter. Until then, the index can be queried, but writes to its table
+// fail with ErrIndexFuncNotRegistered. If the database had to repair its
+// journal when it was opened, the index is rebuilt when its function is
+// registered. The function must return the same values as it did when the
+// index was created, otherwise Rebuild must be used to regenerate the index.
+func (t *Table) NewFuncIndex(name string, fn IndexFunc,
+	opts ...IndexOptions) error {
+	if fn == nil {
+		return errors.New("cete: index function must not be nil")
+	}
+
+	var options IndexOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+
+	return t.newIndex(name, fn, options)
+}
+
+// register registers the function and filter of an existing index, whose
+// function or filter hasn't been registered since the database was opened.
+// ErrAlreadyExists is returned if the index doesn't need them.
+func (t *Table) register(name string, fn IndexFunc,
+	options IndexOptions) error {
+	t.db.commitLock.Lock()
+	idx := t.indexes[Name(name)]
+	if idx == nil || !idx.unregistered() ||
+		idx.function != (fn != nil) ||
+		idx.filtered != (options.Filter != nil) {
+		t.db.commitLock.Unlock()
+		return ErrAlreadyExists
+	}
+
+	idx.fn = fn
+	idx.filter = options.Filter
+	building := idx.building()
+	stale := idx.stale
+	t.db.commitLock.Unlock()
+
+	// Stale indexes which are being built are rebuilt once their build
+	// completes.
+	if building {
+		go idx.runBuild(name)
+
+		if options.Background {
+			return nil
+		}
+
+		return idx.WaitForBuild()
+	}
+
+	if stale {
+		return idx.Rebuild()
+	}
+
+	return nil
+}
+
+// unregistered returns true if the index has a function or filter which
+// hasn't been registered since the database was opened.
+func (i *Index) unregistered() bool {
+	return (i.function && i.fn == nil) || (i.filtered && i.filter == nil)
+}
+
+// values returns the index values of a document.
+func (i *Index) values(data []byte) ([]interface{}, error) {
+	if i.unregistered() {
+		return nil, ErrIndexFuncNotRegistered
+	}
+
+	if i.filter != nil && len(data) > 0 &&
+		!i.filter(Document{data: data, table: i.table}) {
+		return nil, nil
+	}
+
+	if !i.function {
+		return i.indexQuery(data, i.query)
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	return i.fn(Document{data: data, table: i.table})
+}
+
+// checkFuncs returns ErrIndexFuncNotRegistered if any of the table's indexes
+// haven't had their function or filter registered, as writes to the table
+// would not update them. The database's commit lock must be held by the
+// caller.
+func (t *Table) checkFuncs() error {
+	for _, index := range t.indexes {
+		if index.unregistered() {
+			return ErrIndexFuncNotRegistered
+		}
+	}
+
+	return nil
+}
+
+// markStale marks the table's indexes whose function or filter hasn't been
+// registered as stale, so they are rebuilt once it is, and returns true if
+// any were marked. It is used when the journal is repaired while the
+// database is being opened, as the index values of the repaired documents
+// can't be computed.
+func (t *Table) markStale() bool {
+	marked := false
+	tableName := t.name()
+	for indexName, index := range t.indexes {
+		if !index.unregistered() || index.stale {
+			continue
+		}
+
+		index.stale = true
+		marked = true
+
+		t.db.configMutex.Lock()
+		if config := t.db.indexConfig(tableName, string(indexName)); config != nil {
+			config.Stale = true
+		}
+		t.db.configMutex.Unlock()
+	}
+
+	return marked
+}
@@ -0,0 +1,154 @@
+package cete
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/1lann/msgpack"
+)
+
+type funcAccount struct {
+	Email string
+}
+
+func emailDomain(doc Document) ([]interface{}, error) {
+	email, ok := doc.QueryOne("Email").(string)
+	if !ok {
+		return nil, errors.New("missing email")
+	}
+
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return nil, nil
+	}
+
+	return []interface{}{strings.ToLower(email[at+1:])}, nil
+}
+
+func TestFuncIndex(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("func_testing", false))
+	table := db.Table("func_testing")
+
+	panicNotNil(table.Set("jason", funcAccount{Email: "jason@Example.com"}))
+	panicNotNil(table.Set("ben", funcAccount{Email: "ben@test.org"}))
+	panicNotNil(table.Set("nobody", funcAccount{Email: "nobody"}))
+
+	panicNotNil(table.NewFuncIndex("domain", emailDomain))
+
+	if table.Index("domain").CountBetween("example.com", "example.com") != 1 {
+		t.Fatal("there should be 1 document with the domain example.com")
+	}
+
+	panicNotNil(table.Set("drew", funcAccount{Email: "drew@EXAMPLE.com"}))
+	panicNotNil(table.Set("ben", funcAccount{Email: "ben@example.com"}))
+
+	if table.Index("domain").CountBetween("example.com", "example.com") != 3 {
+		t.Fatal("there should be 3 documents with the domain example.com")
+	}
+
+	if table.Index("domain").CountBetween(MinValue, MaxValue) != 3 {
+		t.Fatal("there should be 3 documents in the index")
+	}
+
+	report, err := table.Index("domain").Verify()
+	panicNotNil(err)
+	if !report.OK() {
+		t.Fatalf("index should be consistent, but has %+v", report)
+	}
+
+	if err = table.NewFuncIndex("domain", emailDomain); err != ErrAlreadyExists {
+		t.Fatal("error should be ErrAlreadyExists, but is", err)
+	}
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	table = db.Table("func_testing")
+
+	if table.Index("domain").CountBetween("example.com", "example.com") != 3 {
+		t.Fatal("there should be 3 documents with the domain example.com")
+	}
+
+	err = table.Set("jason", funcAccount{Email: "jason@test.org"})
+	if err != ErrIndexFuncNotRegistered {
+		t.Fatal("error should be ErrIndexFuncNotRegistered, but is", err)
+	}
+
+	err = table.Delete("jason")
+	if err != ErrIndexFuncNotRegistered {
+		t.Fatal("error should be ErrIndexFuncNotRegistered, but is", err)
+	}
+
+	panicNotNil(table.NewFuncIndex("domain", emailDomain))
+	panicNotNil(table.Set("jason", funcAccount{Email: "jason@test.org"}))
+
+	if table.Index("domain").CountBetween("test.org", "test.org") != 1 {
+		t.Fatal("there should be 1 document with the domain test.org")
+	}
+
+	// Simulate a write which was interrupted before the index was updated.
+	old, err := msgpack.Marshal(funcAccount{Email: "ben@example.com"})
+	panicNotNil(err)
+	data, err := msgpack.Marshal(funcAccount{Email: "ben@test.org"})
+	panicNotNil(err)
+	entries, err := msgpack.Marshal([]journalEntry{{
+		Table:  "func_testing",
+		Key:    "ben",
+		Old:    old,
+		New:    data,
+		Intent: true,
+	}})
+	panicNotNil(err)
+
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, 1000)
+	panicNotNil(db.journal.Set(seq, entries, 0))
+	panicNotNil(table.data.Set([]byte("ben"), data, 0))
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("func_testing")
+	if !table.Index("domain").stale {
+		t.Fatal("index should be stale")
+	}
+
+	panicNotNil(table.NewFuncIndex("domain", emailDomain))
+
+	if table.Index("domain").CountBetween("test.org", "test.org") != 2 {
+		t.Fatal("there should be 2 documents with the domain test.org")
+	}
+
+	report, err = table.Index("domain").Verify()
+	panicNotNil(err)
+	if !report.OK() {
+		t.Fatalf("index should be consistent, but has %+v", report)
+	}
+}
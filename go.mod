@@ -1,10 +1,14 @@
 module github.com/1lann/cete
 
-go 1.14
+go 1.23.0
 
 require (
 	github.com/1lann/badger v0.8.2-0.20171214021849-8c80c6bb6364
 	github.com/1lann/msgpack v0.0.0-20190331204203-fdd7618e197c
+	golang.org/x/text v0.28.0
+)
+
+require (
 	github.com/AndreasBriese/bbloom v0.0.0-20190825152654-46b345b51c96 // indirect
 	github.com/dgraph-io/badger v1.6.1 // indirect
 	github.com/dgraph-io/ristretto v0.0.3 // indirect
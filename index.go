@@ -2,9 +2,11 @@ package cete
 
 import (
 	"bytes"
-	"log"
+	"context"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/1lann/badger"
 	"github.com/1lann/msgpack"
@@ -21,17 +23,90 @@ var (
 
 const prefetchSize = 2
 
-// NewIndex creates a new index on the table, using the name as the Query.
+// IndexOptions represents the options of an index.
+type IndexOptions struct {
+	// Unique, if true, prevents more than one document from having the same
+	// index value. Writes that would violate this will fail with
+	// ErrUniqueViolation. Any number of documents may have a nil index
+	// value, such as a nil pointer field, so that optional fields can be
+	// unique. Compound index values which contain nil must still be unique.
+	Unique bool
+
+	// Background, if true, makes NewIndex return as soon as the index has
+	// been created, and the existing documents in the table are indexed in
+	// the background. Use BuildStatus to check the progress of the build.
+	Background bool
+
+	// Query is the query used to get the index values of documents, such
+	// as "Age" or "City,Age" for a compound index. By default, the name of
+	// the index is used as the query.
+	Query string
+
+	// Filter, if set, makes the index a partial index, which only indexes
+	// the documents that Filter returns true for. Like the functions of
+	// NewFuncIndex, filters can't be stored in the database, so the index
+	// must be created again with its filter each time the database is
+	// opened. Until then, writes to its table fail with
+	// ErrIndexFuncNotRegistered.
+	Filter func(doc Document) bool
+
+	// Collation is how the index compares and orders strings. By default,
+	// strings are case-insensitive.
+	Collation Collation
+}
+
+// NewIndex creates a new index on the table, using the name as the Query
+// unless a Query is given in the IndexOptions.
 // The index name must not be empty, and must be no more than 125 bytes
 // long. ErrAlreadyExists will be returned if the index already exists.
+// You can optionally provide IndexOptions to configure the index.
 //
 // NewIndex may take a while if there are already values in the
-// table, as it needs to index all the existing values in the table.
-func (t *Table) NewIndex(name string) error {
+// table, as it needs to index all the existing values in the table, unless
+// the Background option is set. Until all the existing values are indexed,
+// queries on the index will fail with ErrIndexBuilding. The progress of the
+// build is saved as it goes, and it will resume if the database is closed
+// and opened again before it completes.
+//
+// If the build fails, the index is dropped. If the index is unique and
+// existing documents in the table have duplicate values, the build fails
+// with ErrUniqueViolation.
+//
+// If the index has a Filter which hasn't been registered since the database
+// was opened, the Filter is registered and nil is returned. See
+// NewFuncIndex for details.
+func (t *Table) NewIndex(name string, opts ...IndexOptions) error {
+	var options IndexOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+
+	if options.Query == "" {
+		options.Query = name
+	}
+
+	return t.newIndex(name, nil, options)
+}
+
+func (t *Table) newIndex(name string, fn IndexFunc,
+	options IndexOptions) error {
+	if t.db.readOnly {
+		return ErrReadOnly
+	}
+
 	if name == "" || len(name) > 125 {
 		return ErrBadIdentifier
 	}
 
+	if fn != nil {
+		options.Query = ""
+	}
+
+	collator, err := newCollator(options.Collation)
+	if err != nil {
+		return err
+	}
+
 	t.db.configMutex.Lock()
 
 	tableName := t.name()
@@ -43,7 +118,7 @@ func (t *Table) NewIndex(name string) error {
 			for _, index := range table.Indexes {
 				if index.IndexName == name {
 					t.db.configMutex.Unlock()
-					return ErrAlreadyExists
+					return t.register(name, fn, options)
 				}
 			}
 		}
@@ -61,7 +136,16 @@ func (t *Table) NewIndex(name string) error {
 	}
 
 	indexes := t.db.config.Tables[tableConfigKey].Indexes
-	indexes = append(indexes, indexConfig{IndexName: name})
+	indexes = append(indexes, indexConfig{
+		IndexName: name,
+		Query:     options.Query,
+		Func:      fn != nil,
+		Filtered:  options.Filter != nil,
+		Collation: options.Collation,
+		Encoding:  currentEncoding,
+		Unique:    options.Unique,
+		Building:  true,
+	})
 	t.db.config.Tables[tableConfigKey].Indexes = indexes
 	if err = t.db.writeConfig(); err != nil {
 		t.db.configMutex.Unlock()
@@ -71,39 +155,56 @@ func (t *Table) NewIndex(name string) error {
 	t.db.configMutex.Unlock()
 
 	idx := &Index{
-		index: kv,
-		table: t,
+		index:    kv,
+		table:    t,
+		query:    options.Query,
+		function: fn != nil,
+		fn:       fn,
+		filtered: options.Filter != nil,
+		filter:   options.Filter,
+		collator: collator,
+		encoding: currentEncoding,
+		unique:   options.Unique,
+		lock:     new(sync.RWMutex),
+		build:    newIndexBuild(""),
 	}
 
+	t.db.commitLock.Lock()
 	t.indexes[Name(name)] = idx
+	t.db.commitLock.Unlock()
+
+	go idx.runBuild(name)
 
-	if err = idx.indexValues(name); err != nil {
-		log.Println("cete: error while indexing \""+
-			idx.name()+"\", index likely corrupt:", err)
+	if options.Background {
 		return nil
 	}
 
-	return nil
+	return idx.WaitForBuild()
 }
 
-func (i *Index) indexValues(name string) error {
-	i.table.Between(MinValue, MaxValue).Do(func(key string, counter uint64, doc Document) error {
-		results, err := i.indexQuery(doc.data, name)
+// NewUniqueIndex creates a new unique index on the table. It is shorthand
+// for NewIndex(name, IndexOptions{Unique: true}).
+func (t *Table) NewUniqueIndex(name string) error {
+	return t.NewIndex(name, IndexOptions{Unique: true})
+}
+
+func (i *Index) indexValues() error {
+	return i.table.between(context.Background(), MinValue, MaxValue,
+		true).Do(func(key string, counter uint64, doc Document) error {
+		results, err := i.values(doc.data)
 		if err != nil {
 			return nil
 		}
 
-		for _, result := range results {
-			err = i.addToIndex(valueToBytes(result), key)
+		for _, indexKey := range i.indexKeys(results) {
+			err = i.addToIndex(indexKey, key)
 			if err != nil {
-				log.Println("cete: index error for index \""+name+"\":", err)
+				return err
 			}
 		}
 
 		return nil
 	}, 20)
-
-	return nil
 }
 
 func (i *Index) indexQuery(data []byte, query string) ([]interface{}, error) {
@@ -128,6 +229,10 @@ func (i *Index) indexQuery(data []byte, query string) ([]interface{}, error) {
 				return nil, err
 			}
 
+			if len(res) == 0 {
+				return nil, nil
+			}
+
 			rd.Reset(data)
 			dec.Reset(rd)
 
@@ -146,8 +251,9 @@ func (i *Index) indexQuery(data []byte, query string) ([]interface{}, error) {
 
 // One puts the first matching value with the index's key into dst. dst
 // must either be a pointer or nil if you would like to only get the key/counter
-// and check for existence. Note that indexes are non-unique, a single index key
-// can map to multiple values. Use GetAll to get all such matching values.
+// and check for existence. Note that unless the index was created as a unique
+// index, a single index key can map to multiple values. Use GetAll to get all
+// such matching values.
 func (i *Index) One(key interface{}, dst interface{}) (string, uint64, error) {
 	r := i.GetAll(key)
 	defer r.Close()
@@ -169,8 +275,27 @@ func (i *Index) One(key interface{}, dst interface{}) (string, uint64, error) {
 
 // GetAll returns all the matching values as a range for the provided index key.
 func (i *Index) GetAll(key interface{}) *Range {
+	return i.GetAllCtx(context.Background(), key)
+}
+
+// GetAllCtx is like GetAll, but the range is closed and returns ctx.Err()
+// when the context is done.
+func (i *Index) GetAllCtx(ctx context.Context, key interface{}) *Range {
+	if i.building() {
+		return newRange(func() (string, []byte, uint64, error) {
+			return "", nil, 0, ErrIndexBuilding
+		}, func() {}, nil)
+	}
+
+	indexKey, err := i.valueToBytes(key)
+	if err != nil {
+		return newRange(func() (string, []byte, uint64, error) {
+			return "", nil, 0, err
+		}, func() {}, nil)
+	}
+
 	var item badger.KVItem
-	err := i.index.Get(valueToBytes(key), &item)
+	err = i.kv().Get(indexKey, &item)
 	if err != nil {
 		return newRange(func() (string, []byte, uint64, error) {
 			return "", nil, 0, err
@@ -184,7 +309,7 @@ func (i *Index) GetAll(key interface{}) *Range {
 		}, func() {}, nil)
 	}
 
-	r, err := i.getAllValues(itemValue)
+	r, err := i.getAllValues(ctx, itemValue)
 	if err != nil {
 		return newRange(func() (string, []byte, uint64, error) {
 			return "", nil, 0, err
@@ -193,24 +318,23 @@ func (i *Index) GetAll(key interface{}) *Range {
 	return r
 }
 
-func (i *Index) getAllValues(indexValue []byte) (*Range, error) {
+func (i *Index) getAllValues(ctx context.Context,
+	indexValue []byte) (*Range, error) {
 	var keys []string
 	err := msgpack.Unmarshal(indexValue, &keys)
 	if err != nil {
-		log.Println("cete: corrupt index \""+i.name()+"\":", err)
-		return nil, ErrIndexError
+		return nil, i.indexError("", err)
 	}
 
 	if len(keys) == 0 {
-		log.Println("cete: corrupt index \""+i.name()+"\":", err)
-		return nil, ErrIndexError
+		return nil, i.indexError("", ErrCorruptIndex)
 	}
 
 	c := 0
 	var value []byte
 	var item badger.KVItem
 
-	return newRange(func() (string, []byte, uint64, error) {
+	r := newRangeCtx(ctx, func() (string, []byte, uint64, error) {
 		for {
 			if c >= len(keys) {
 				return "", nil, 0, ErrEndOfRange
@@ -222,7 +346,7 @@ func (i *Index) getAllValues(indexValue []byte) (*Range, error) {
 			}
 
 			itemValue := getItemValue(&item)
-			if itemValue == nil {
+			if itemValue == nil || itemExpired(&item) {
 				c++
 				continue
 			}
@@ -233,7 +357,13 @@ func (i *Index) getAllValues(indexValue []byte) (*Range, error) {
 			c++
 			return keys[c-1], value, item.Counter(), nil
 		}
-	}, func() {}, i.table), nil
+	}, func() {}, i.table)
+
+	r.keys = func() ([]string, error) {
+		return keys, nil
+	}
+
+	return r, nil
 }
 
 // Between returns a Range of documents between the lower and upper index values
@@ -246,21 +376,42 @@ func (i *Index) getAllValues(indexValue []byte) (*Range, error) {
 // You can use cete.MinValue and cete.MaxValue to specify minimum and maximum
 // bound values.
 func (i *Index) Between(lower, upper interface{}, reverse ...bool) *Range {
+	return i.BetweenCtx(context.Background(), lower, upper, reverse...)
+}
+
+// BetweenCtx is like Between, but the range is closed and returns ctx.Err()
+// when the context is done.
+func (i *Index) BetweenCtx(ctx context.Context, lower, upper interface{},
+	reverse ...bool) *Range {
+	if i.building() {
+		return newRange(func() (string, []byte, uint64, error) {
+			return "", nil, 0, ErrIndexBuilding
+		}, func() {}, nil)
+	}
+
 	if lower == MaxValue || upper == MinValue {
 		return newRange(func() (string, []byte, uint64, error) {
 			return "", nil, 0, ErrEndOfRange
 		}, func() {}, nil)
 	}
 
+	var lowerBytes []byte
+	upperBytes, err := i.valueToBytes(upper)
+	if err == nil {
+		lowerBytes, err = i.valueToBytes(lower)
+	}
+	if err != nil {
+		return newRange(func() (string, []byte, uint64, error) {
+			return "", nil, 0, err
+		}, func() {}, nil)
+	}
+
 	shouldReverse := (len(reverse) > 0) && reverse[0]
 
 	itOpts := badger.DefaultIteratorOptions
 	itOpts.PrefetchSize = prefetchSize
 	itOpts.Reverse = shouldReverse
-	it := i.index.NewIterator(itOpts)
-
-	upperBytes := valueToBytes(upper)
-	lowerBytes := valueToBytes(lower)
+	it := i.kv().NewIterator(itOpts)
 
 	if !shouldReverse {
 		if lower == MinValue {
@@ -278,57 +429,127 @@ func (i *Index) Between(lower, upper interface{}, reverse ...bool) *Range {
 
 	var lastRange *Range
 
-	return newRange(i.betweenNext(it, lastRange, shouldReverse, lower, upper),
+	r := newRangeCtx(ctx, i.betweenNext(ctx, it, lastRange, shouldReverse,
+		lower, upper, lowerBytes, upperBytes),
 		func() {
 			if lastRange != nil {
 				lastRange.Close()
 			}
 			it.Close()
 		}, i.table)
+
+	r.keys = func() ([]string, error) {
+		ranges, err := i.betweenRanges(lower, upper)
+		if err != nil {
+			return nil, err
+		}
+
+		return i.keysInRanges(ctx, ranges, shouldReverse)
+	}
+
+	return r
 }
 
 // CountBetween returns the number of documents whose index values are
 // within the given bounds. It is an optimized version of
 // Between(lower, upper).Count(). Note that like with Between, double counting
 // for documents is possible if the document has multiple unique index values.
+// A count of 0 is returned if the index is still being built. Expired
+// documents are counted until they are deleted from the index.
 func (i *Index) CountBetween(lower, upper interface{}) int64 {
-	if lower == MaxValue || upper == MinValue {
+	if i.building() {
 		return 0
 	}
 
-	itOpts := badger.DefaultIteratorOptions
-	itOpts.PrefetchSize = prefetchSize
-	it := i.index.NewIterator(itOpts)
+	ranges, err := i.betweenRanges(lower, upper)
+	if err != nil {
+		return 0
+	}
 
-	upperBytes := valueToBytes(upper)
-	lowerBytes := valueToBytes(lower)
+	return i.countRanges(ranges)
+}
 
-	if lower == MinValue {
-		it.Rewind()
-	} else {
-		it.Seek(lowerBytes)
+// Values returns each distinct index value within the given bounds and the
+// number of documents with it, in the order of the index. You can reverse
+// the order by specifying true to the optional reverse parameter.
+//
+// The values are decoded from the index without reading any documents, so
+// strings are returned as they were indexed, which is lowercase with the
+// default collation, byte slices are returned as strings, and times are
+// returned as a time.Time in UTC. Values of compound indexes are returned as
+// a []interface{}. If the index has a Collation with a Locale, whose values
+// can't be decoded, the value of one of the documents with each index value
+// is returned instead, like with Histogram.
+//
+// Like CountBetween, expired documents are counted until they are deleted
+// from the index.
+func (i *Index) Values(lower, upper interface{}, reverse ...bool) ([]Bucket,
+	error) {
+	if i.building() {
+		return nil, ErrIndexBuilding
 	}
 
-	var count int64
+	ranges, err := i.betweenRanges(lower, upper)
+	if err != nil {
+		return nil, err
+	}
+
+	decodable := atomic.LoadInt32(&i.encoding) == currentEncoding &&
+		collationOf(i).Locale == ""
+	shouldReverse := len(reverse) > 0 && reverse[0]
+
+	var results []Bucket
 
-	for it.Valid() {
-		if upper != MaxValue &&
-			bytes.Compare(it.Item().Key(), upperBytes) > 0 {
-			return count
+	i.scanRanges(ranges, shouldReverse, func(key, value []byte) bool {
+		if len(value) == 0 {
+			return true
 		}
 
-		itemValue := getItemValue(it.Item())
-		if len(itemValue) < 5 {
-			// Malformed index value my cause a panic here
-			count += decodeArrayCount(itemValue)
-		} else {
-			count += decodeArrayCount(itemValue[:5])
+		bucket := Bucket{Count: decodeArrayCount(value)}
+
+		values, ok := decodeValues(key)
+		if decodable && ok {
+			bucket.Value = values[0]
+			if len(values) > 1 {
+				bucket.Value = values
+			}
+		} else if bucket.Value, err = i.bucketValue(key, value); err != nil {
+			return false
 		}
 
-		it.Next()
+		results = append(results, bucket)
+		return true
+	})
+
+	return results, err
+}
+
+// betweenRanges returns the key ranges of the index values within the
+// bounds, which is empty if there can't be any.
+func (i *Index) betweenRanges(lower, upper interface{}) ([]keyRange, error) {
+	if lower == MaxValue || upper == MinValue {
+		return nil, nil
+	}
+
+	r := keyRange{exact: true}
+
+	if lower != MinValue {
+		b, err := i.valueToBytes(lower)
+		if err != nil {
+			return nil, err
+		}
+		r.lower = b
+	}
+
+	if upper != MaxValue {
+		b, err := i.valueToBytes(upper)
+		if err != nil {
+			return nil, err
+		}
+		r.upper = b
 	}
 
-	return count
+	return []keyRange{r}, nil
 }
 
 func decodeArrayCount(header []byte) int64 {
@@ -345,17 +566,14 @@ func decodeArrayCount(header []byte) int64 {
 	return 0
 }
 
-func (i *Index) betweenNext(it *badger.Iterator, lastRange *Range,
-	shouldReverse bool, lower,
-	upper interface{}) func() (string, []byte, uint64, error) {
-	upperBytes := valueToBytes(upper)
-	lowerBytes := valueToBytes(lower)
-
+func (i *Index) betweenNext(ctx context.Context, it *badger.Iterator,
+	lastRange *Range, shouldReverse bool, lower, upper interface{},
+	lowerBytes, upperBytes []byte) func() (string, []byte, uint64, error) {
 	var entry bufferEntry
 
 	return func() (string, []byte, uint64, error) {
 		if lastRange != nil {
-			entry = <-lastRange.buffer
+			entry = <-lastRange.entries()
 			if entry.err != ErrEndOfRange {
 				return entry.key, entry.data, entry.counter, entry.err
 			}
@@ -372,7 +590,7 @@ func (i *Index) betweenNext(it *badger.Iterator, lastRange *Range,
 				return "", nil, 0, ErrEndOfRange
 			}
 
-			r, err := i.getAllValues(getItemValue(it.Item()))
+			r, err := i.getAllValues(ctx, getItemValue(it.Item()))
 			it.Next()
 			if err != nil {
 				continue
@@ -380,7 +598,7 @@ func (i *Index) betweenNext(it *badger.Iterator, lastRange *Range,
 
 			lastRange = r
 
-			entry = <-lastRange.buffer
+			entry = <-lastRange.entries()
 			if entry.err != ErrEndOfRange {
 				return entry.key, entry.data, entry.counter, entry.err
 			}
@@ -402,10 +620,12 @@ func (i *Index) All(reverse ...bool) *Range {
 // All further calls to the index will result in undefined behaviour.
 // Note that table.Index("deleted index") will be nil.
 func (i *Index) Drop() error {
-	i.table.db.configMutex.Lock()
-	defer i.table.db.configMutex.Unlock()
+	if i.table.db.readOnly {
+		return ErrReadOnly
+	}
 
-	tableName := i.table.name()
+	i.table.db.commitLock.Lock()
+	defer i.table.db.commitLock.Unlock()
 
 	var indexName string
 
@@ -419,6 +639,15 @@ func (i *Index) Drop() error {
 		return ErrNotFound
 	}
 
+	return i.drop(indexName)
+}
+
+func (i *Index) drop(indexName string) error {
+	i.table.db.configMutex.Lock()
+	defer i.table.db.configMutex.Unlock()
+
+	tableName := i.table.name()
+
 tableLoop:
 	for key, table := range i.table.db.config.Tables {
 		if table.TableName == tableName {
@@ -437,6 +666,7 @@ tableLoop:
 		return err
 	}
 
+	i.stopMigration()
 	i.index.Close()
 
 	delete(i.table.indexes, Name(indexName))
@@ -0,0 +1,253 @@
+package cete
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+
+	"github.com/1lann/badger"
+	"github.com/1lann/msgpack"
+)
+
+// journalEntry represents a write to a single document. Intent is true for
+// single document writes, which may fail their counter check after being
+// written to the journal, so the document update may never happen. Expires
+// is the expiry time of the new document, or 0 if it never expires.
+type journalEntry struct {
+	Table   string
+	Key     string
+	Old     []byte
+	New     []byte
+	Expires int64
+	Intent  bool
+}
+
+func (d *DB) writeJournal(entries []journalEntry) ([]byte, error) {
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, atomic.AddUint64(&d.journalSeq, 1))
+
+	if err := d.setJournal(seq, entries); err != nil {
+		return nil, err
+	}
+
+	return seq, nil
+}
+
+func (d *DB) setJournal(seq []byte, entries []journalEntry) error {
+	data, err := msgpack.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	return d.journal.Set(seq, data, 0)
+}
+
+// commitJournal writes the entries to the journal, then applies them. If
+// the indexes fail to update, the entries are left in the journal as
+// intents, so that the indexes are repaired from the current documents the
+// next time the database is opened, without overwriting any documents
+// written since. skipped is returned as described by applyJournal.
+func (d *DB) commitJournal(entries []journalEntry) (skipped, err error) {
+	seq, err := d.writeJournal(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.applyDocuments(entries); err != nil {
+		return nil, err
+	}
+
+	skipped, err = d.applyJournal(entries)
+	if err != nil {
+		intents := make([]journalEntry, len(entries))
+		for i, entry := range entries {
+			entry.Intent = true
+			intents[i] = entry
+		}
+
+		if journalErr := d.setJournal(seq, intents); journalErr != nil {
+			return nil, journalErr
+		}
+
+		return nil, err
+	}
+
+	return skipped, d.journal.Delete(seq)
+}
+
+// applyDocuments writes the documents of the entries to their tables.
+func (d *DB) applyDocuments(entries []journalEntry) error {
+	for _, entry := range entries {
+		t := d.tables[Name(entry.Table)]
+		if t == nil {
+			continue
+		}
+
+		var err error
+		if entry.New == nil {
+			err = t.data.Delete([]byte(entry.Key))
+		} else {
+			err = t.setDocument(entry.Key, entry.New, entry.Expires)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyJournal applies the entries to the indexes of their tables, once
+// their documents have been written by applyDocuments. If any of the
+// indexes fail to update, the other entries are still applied, and an
+// IndexError is returned. If any of the values of the entries can't be
+// indexed, they're left out, and an IndexError wrapping ErrUnsupportedValue
+// is returned as skipped.
+func (d *DB) applyJournal(entries []journalEntry) (skipped, err error) {
+	additions := make([][]diffEntry, len(entries))
+	var indexErr error
+
+	for i, entry := range entries {
+		t := d.tables[Name(entry.Table)]
+		if t == nil {
+			continue
+		}
+
+		// All removals must be applied before additions, as another entry
+		// may be taking over the value of a unique index.
+		var removals []diffEntry
+		var entrySkipped error
+		additions[i], removals, entrySkipped = t.diffIndexes(entry.Key,
+			entry.Old, entry.New)
+		if entrySkipped != nil {
+			skipped = entrySkipped
+		}
+
+		if err = t.removeFromIndexes(entry.Key, removals); err != nil {
+			indexErr = err
+		}
+	}
+
+	for i, entry := range entries {
+		if t := d.tables[Name(entry.Table)]; t != nil {
+			if err := t.addToIndexes(entry.Key, additions[i]); err != nil {
+				indexErr = err
+			}
+		}
+	}
+
+	return skipped, indexErr
+}
+
+// recoverJournal finishes the writes of any commits that were interrupted
+// before they completed, and repairs the indexes of any documents that
+// were being written, so that tables and their indexes are consistent.
+func (d *DB) recoverJournal() error {
+	itOpts := badger.DefaultIteratorOptions
+	it := d.journal.NewIterator(itOpts)
+
+	var seqs [][]byte
+	var records [][]journalEntry
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		var entries []journalEntry
+		if err := msgpack.Unmarshal(getItemValue(it.Item()),
+			&entries); err != nil {
+			it.Close()
+			return err
+		}
+
+		seq := make([]byte, len(it.Item().Key()))
+		copy(seq, it.Item().Key())
+		seqs = append(seqs, seq)
+		records = append(records, entries)
+	}
+
+	it.Close()
+
+	stale := false
+	for i, entries := range records {
+		for _, entry := range entries {
+			if t := d.tables[Name(entry.Table)]; t != nil && t.markStale() {
+				stale = true
+			}
+		}
+
+		if err := d.recoverEntries(entries); err != nil {
+			return err
+		}
+
+		if err := d.journal.Delete(seqs[i]); err != nil {
+			return err
+		}
+	}
+
+	if len(seqs) > 0 {
+		d.journalSeq = binary.BigEndian.Uint64(seqs[len(seqs)-1])
+	}
+
+	if stale {
+		return d.writeConfig()
+	}
+
+	return nil
+}
+
+func (d *DB) recoverEntries(entries []journalEntry) error {
+	current := make([][]byte, len(entries))
+
+	for i, entry := range entries {
+		t := d.tables[Name(entry.Table)]
+		if t == nil {
+			continue
+		}
+
+		var err error
+		if !entry.Intent {
+			if entry.New == nil {
+				err = t.data.Delete([]byte(entry.Key))
+			} else {
+				err = t.setDocument(entry.Key, entry.New,
+					entry.Expires)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		var item badger.KVItem
+		if err = t.data.Get([]byte(entry.Key), &item); err != nil {
+			return err
+		}
+
+		if itemValue := getItemValue(&item); itemValue != nil {
+			current[i] = make([]byte, len(itemValue))
+			copy(current[i], itemValue)
+		}
+
+		// Remove any index values of the old and new document which the
+		// current document doesn't have. They may have already been
+		// removed before the write was interrupted.
+		_, oldRemovals, _ := t.diffIndexes(entry.Key, entry.Old, current[i])
+		_, newRemovals, _ := t.diffIndexes(entry.Key, entry.New, current[i])
+		if err = t.removeFromIndexes(entry.Key,
+			append(oldRemovals, newRemovals...)); err != nil &&
+			!errors.Is(err, ErrCorruptIndex) {
+			return err
+		}
+	}
+
+	for i, entry := range entries {
+		t := d.tables[Name(entry.Table)]
+		if t == nil {
+			continue
+		}
+
+		additions, _, _ := t.diffIndexes(entry.Key, nil, current[i])
+		if err := t.addToIndexes(entry.Key, additions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package cete
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/1lann/badger"
+	"github.com/1lann/msgpack"
+)
+
+func TestJournalRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("journal_testing", false))
+	table := db.Table("journal_testing")
+	panicNotNil(table.NewIndex("Age"))
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", Age: 18}))
+	panicNotNil(table.Set("ben", Person{Name: "Ben", Age: 19}))
+
+	it := db.journal.NewIterator(badger.DefaultIteratorOptions)
+	it.Rewind()
+	if it.Valid() {
+		t.Fatal("journal should be empty, but isn't")
+	}
+	it.Close()
+
+	jason18, err := msgpack.Marshal(Person{Name: "Jason", Age: 18})
+	panicNotNil(err)
+	jason20, err := msgpack.Marshal(Person{Name: "Jason", Age: 20})
+	panicNotNil(err)
+	ben19, err := msgpack.Marshal(Person{Name: "Ben", Age: 19})
+	panicNotNil(err)
+	ben21, err := msgpack.Marshal(Person{Name: "Ben", Age: 21})
+	panicNotNil(err)
+
+	writeEntry := func(seq uint64, entry journalEntry) {
+		data, err := msgpack.Marshal([]journalEntry{entry})
+		panicNotNil(err)
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		panicNotNil(db.journal.Set(key, data, 0))
+	}
+
+	// The process stopped after updating jason, but before updating
+	// the index.
+	writeEntry(1, journalEntry{
+		Table:  "journal_testing",
+		Key:    "jason",
+		Old:    jason18,
+		New:    jason20,
+		Intent: true,
+	})
+	panicNotNil(table.data.Set([]byte("jason"), jason20, 0))
+
+	// The process stopped before updating ben.
+	writeEntry(2, journalEntry{
+		Table:  "journal_testing",
+		Key:    "ben",
+		Old:    ben19,
+		New:    ben21,
+		Intent: true,
+	})
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	index := db.Table("journal_testing").Index("Age")
+
+	for age, expected := range map[int]int64{18: 0, 19: 1, 20: 1, 21: 0} {
+		if count := index.CountBetween(age, age); count != expected {
+			t.Fatal("count for", age, "should be", expected, "but is", count)
+		}
+	}
+
+	var person Person
+	_, _, err = index.One(19, &person)
+	panicNotNil(err)
+
+	if person.Name != "Ben" {
+		t.Fatal("person should be Ben, but is", person.Name)
+	}
+}
@@ -0,0 +1,34 @@
+package cete
+
+import "log"
+
+// Logger is used by a database to log warnings, such as invalid range
+// bounds, and errors which occur in the background, such as while
+// deleting expired documents. *log.Logger satisfies Logger.
+type Logger interface {
+	Println(v ...interface{})
+}
+
+// loggerHolder allows a nil Logger to be stored in an atomic.Value.
+type loggerHolder struct {
+	Logger
+}
+
+// SetLogger sets the logger of the database. By default, the standard
+// logger of the log package is used. If logger is nil, nothing is logged.
+func (d *DB) SetLogger(logger Logger) {
+	d.logger.Store(loggerHolder{logger})
+}
+
+// log logs the values with the database's logger.
+func (d *DB) log(v ...interface{}) {
+	holder, ok := d.logger.Load().(loggerHolder)
+	if !ok {
+		log.Println(v...)
+		return
+	}
+
+	if holder.Logger != nil {
+		holder.Println(v...)
+	}
+}
@@ -0,0 +1,112 @@
+package cete
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testLogger struct {
+	mutex *sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Println(v ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.lines = append(l.lines, strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
+
+func TestIndexError(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	logger := &testLogger{mutex: new(sync.Mutex)}
+	db.SetLogger(logger)
+
+	panicNotNil(db.NewTable("error_testing"))
+	table := db.Table("error_testing")
+	panicNotNil(table.NewIndex("Score"))
+
+	err = table.Set("map", encodingScore{
+		Score: map[string]interface{}{"a": 1},
+	})
+	if !errors.Is(err, ErrUnsupportedValue) {
+		t.Fatal("error should be ErrUnsupportedValue, but is", err)
+	}
+
+	if len(logger.lines) != 0 {
+		t.Fatal("logger should not have been used, but has", logger.lines)
+	}
+
+	// Corrupt the index value of 10.
+	index := table.Index("Score")
+	panicNotNil(index.index.Set(valueToBytes(10), []byte{0xc1}, 0))
+
+	err = table.Set("a", encodingScore{Score: 10})
+	var indexErr *IndexError
+	if !errors.As(err, &indexErr) {
+		t.Fatal("error should be an IndexError, but is", err)
+	}
+
+	if indexErr.Table != "error_testing" || indexErr.Index != "Score" ||
+		indexErr.Key != "a" {
+		t.Fatalf("index error should be for error_testing/Score: a, "+
+			"but is %+v", *indexErr)
+	}
+
+	if !errors.Is(err, ErrIndexError) {
+		t.Fatal("error should match ErrIndexError")
+	}
+
+	var doc encodingScore
+	_, err = table.Get("a", &doc)
+	panicNotNil(err)
+	if doc.Score != int64(10) {
+		t.Fatal("document should have been written, but is", doc)
+	}
+
+	_, _, err = index.One(10, nil)
+	if !errors.As(err, &indexErr) || indexErr.Key != "" {
+		t.Fatal("error should be an IndexError without a key, but is", err)
+	}
+
+	// Remove b from the index value of 20.
+	panicNotNil(table.Set("b", encodingScore{Score: 20}))
+	panicNotNil(index.deleteFromIndex(valueToBytes(20), "b"))
+
+	err = table.Set("b", encodingScore{Score: 30})
+	if !errors.As(err, &indexErr) || !errors.Is(err, ErrCorruptIndex) ||
+		indexErr.Key != "b" {
+		t.Fatal("error should be an IndexError wrapping ErrCorruptIndex, "+
+			"but is", err)
+	}
+
+	if key, _, err := index.One(30, nil); err != nil || key != "b" {
+		t.Fatal("b should have been indexed, but hasn't:", key, err)
+	}
+
+	if len(logger.lines) != 0 {
+		t.Fatal("logger should not have been used, but has", logger.lines)
+	}
+}
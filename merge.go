@@ -0,0 +1,177 @@
+package cete
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// Intersect returns a range of the documents which are in all of the
+// ranges. The ranges must be from the same table, and the documents are
+// returned in the order of their keys, without duplicates.
+//
+// The keys of ranges returned by an Index's GetAll and Between are read
+// from the index, so documents are only read once the result is known.
+// Other ranges, such as ranges returned by Filter, and ranges which have
+// already been read from or skipped, are read in full. Documents which have
+// already been read from or skipped are not included, and the ranges can't be
+// read from once they have been merged.
+func Intersect(ranges ...*Range) *Range {
+	return mergeRanges(ranges, func(sets []map[string]bool) map[string]bool {
+		result := sets[0]
+		for _, set := range sets[1:] {
+			for key := range result {
+				if !set[key] {
+					delete(result, key)
+				}
+			}
+		}
+
+		return result
+	})
+}
+
+// Union returns a range of the documents which are in any of the ranges.
+// See Intersect for details.
+func Union(ranges ...*Range) *Range {
+	return mergeRanges(ranges, func(sets []map[string]bool) map[string]bool {
+		result := sets[0]
+		for _, set := range sets[1:] {
+			for key := range set {
+				result[key] = true
+			}
+		}
+
+		return result
+	})
+}
+
+// Except returns a range of the documents in r which aren't in any of the
+// other ranges. See Intersect for details.
+func Except(r *Range, ranges ...*Range) *Range {
+	return mergeRanges(append([]*Range{r}, ranges...),
+		func(sets []map[string]bool) map[string]bool {
+			result := sets[0]
+			for _, set := range sets[1:] {
+				for key := range set {
+					delete(result, key)
+				}
+			}
+
+			return result
+		})
+}
+
+// mergeRanges returns a range of the documents with the keys returned by
+// merge, which is given the set of keys of each of the ranges.
+func mergeRanges(ranges []*Range,
+	merge func(sets []map[string]bool) map[string]bool) *Range {
+	closer := func() {
+		for _, r := range ranges {
+			r.Close()
+		}
+	}
+
+	if len(ranges) == 0 {
+		return newRange(func() (string, []byte, uint64, error) {
+			return "", nil, 0, ErrEndOfRange
+		}, func() {}, nil)
+	}
+
+	var table *Table
+	for _, r := range ranges {
+		if r.table == nil {
+			continue
+		}
+
+		if table != nil && table != r.table {
+			closer()
+			return newRange(func() (string, []byte, uint64, error) {
+				return "", nil, 0, errors.New("cete: ranges must be from " +
+					"the same table")
+			}, func() {}, nil)
+		}
+
+		table = r.table
+	}
+
+	var result *Range
+
+	return newRange(func() (string, []byte, uint64, error) {
+		if result == nil {
+			sets := make([]map[string]bool, len(ranges))
+			for n, r := range ranges {
+				keys, err := r.allKeys()
+				if err != nil {
+					return "", nil, 0, err
+				}
+
+				sets[n] = make(map[string]bool, len(keys))
+				for _, key := range keys {
+					sets[n][key] = true
+				}
+			}
+
+			closer()
+
+			set := merge(sets)
+			keys := make([]string, 0, len(set))
+			for key := range set {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			if table == nil {
+				return "", nil, 0, ErrEndOfRange
+			}
+
+			result = table.getKeys(context.Background(), keys)
+		}
+
+		entry := <-result.entries()
+		return entry.key, entry.data, entry.counter, entry.err
+	}, closer, table)
+}
+
+// allKeys returns the keys of the remaining documents in the range, and
+// consumes the range. The keys are only read without the documents if the
+// range hasn't been read from or skipped yet, otherwise the rest of the range
+// is read in full.
+func (r *Range) allKeys() ([]string, error) {
+	direct := false
+	if r.keys != nil {
+		r.start.Do(func() {
+			direct = true
+		})
+	}
+
+	if direct {
+		keys, err := r.keys()
+		if err == nil {
+			err = r.ctx.Err()
+		}
+
+		// The range was never started, so end it for any later reads.
+		r.Close()
+		if err != nil {
+			r.buffer <- bufferEntry{err: err}
+			close(r.buffer)
+			return nil, err
+		}
+
+		r.buffer <- bufferEntry{err: ErrEndOfRange}
+		close(r.buffer)
+		return keys, nil
+	}
+
+	var keys []string
+	for r.Next() {
+		keys = append(keys, r.Key())
+	}
+
+	if r.Error() != ErrEndOfRange {
+		return nil, r.Error()
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,132 @@
+package cete
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMergeRanges(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("merge_testing"))
+	table := db.Table("merge_testing")
+	panicNotNil(table.NewIndex("Age"))
+	panicNotNil(table.NewIndex("City"))
+	panicNotNil(table.NewIndex("Likes.*"))
+
+	people := map[string]Person{
+		"ben":   {Name: "Ben", City: "Melbourne", Age: 19, Likes: []string{"c", "go"}},
+		"drew":  {Name: "Drew", City: "London", Age: 17, Likes: []string{"js"}},
+		"jason": {Name: "Jason", City: "Sydney", Age: 18, Likes: []string{"go", "js"}},
+		"kevin": {Name: "Kevin", City: "Sydney", Age: 25, Likes: []string{"rust"}},
+		"mark":  {Name: "Mark", City: "Sydney", Age: 16, Likes: []string{"go"}},
+	}
+
+	for key, person := range people {
+		panicNotNil(table.Set(key, person))
+	}
+
+	expectKeys := func(r *Range, expected ...string) {
+		t.Helper()
+
+		var results []string
+		for r.Next() {
+			var person Person
+			panicNotNil(r.Decode(&person))
+			if !person.IsSame(people[r.Key()]) {
+				t.Fatal("document of", r.Key(), "should be", people[r.Key()],
+					"but is", person)
+			}
+
+			results = append(results, r.Key())
+		}
+
+		if r.Error() != ErrEndOfRange {
+			t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+		}
+
+		if strings.Join(results, ",") != strings.Join(expected, ",") {
+			t.Fatal("keys should be", expected, "but are", results)
+		}
+	}
+
+	city := table.Index("City")
+	age := table.Index("Age")
+	likes := table.Index("Likes.*")
+
+	expectKeys(Intersect(city.GetAll("Sydney"), age.Between(18, MaxValue)),
+		"jason", "kevin")
+	expectKeys(Intersect(city.GetAll("Sydney"), likes.GetAll("go"),
+		age.Between(MinValue, 18, true)), "jason", "mark")
+	expectKeys(Intersect(city.GetAll("Sydney"), likes.GetAll("none")))
+
+	expectKeys(Union(likes.GetAll("rust"), likes.GetAll("c"),
+		city.GetAll("London")), "ben", "drew", "kevin")
+
+	expectKeys(Except(city.GetAll("Sydney"), likes.GetAll("go")), "kevin")
+	expectKeys(Except(table.All(), age.Between(17, 19)), "kevin", "mark")
+
+	expectKeys(Intersect(likes.GetAll("js"),
+		table.All().Filter(func(doc Document) (bool, error) {
+			return doc.QueryInt("Age") > 17, nil
+		})), "jason")
+
+	expectKeys(Union())
+
+	panicNotNil(db.NewTable("other_testing"))
+	r := Union(table.All(), db.Table("other_testing").All())
+	if r.Next() || r.Error() == ErrEndOfRange {
+		t.Fatal("union of different tables should fail, but has error",
+			r.Error())
+	}
+
+	r = Intersect(city.GetAll("Sydney"), age.Between(map[string]int{}, 10))
+	if r.Next() || r.Error() != ErrUnsupportedValue {
+		t.Fatal("error should be ErrUnsupportedValue, but is", r.Error())
+	}
+
+	// Documents which have been skipped or read aren't merged.
+	expectKeys(Union(age.Between(MinValue, MaxValue).Skip(2)),
+		"ben", "jason", "kevin")
+
+	r = age.Between(MinValue, MaxValue)
+	if !r.Next() || r.Key() != "mark" {
+		t.Fatal("first key should be mark, but is", r.Key(), r.Error())
+	}
+	expectKeys(Union(r), "ben", "drew", "jason", "kevin")
+
+	// Merged ranges are consumed.
+	r = age.Between(MinValue, MaxValue)
+	expectKeys(Union(r), "ben", "drew", "jason", "kevin", "mark")
+	if r.Next() || r.Error() != ErrEndOfRange {
+		t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r = Intersect(city.GetAll("Sydney"), age.BetweenCtx(ctx, 18, MaxValue))
+	if r.Next() || r.Error() != context.Canceled {
+		t.Fatal("error should be context.Canceled, but is", r.Error())
+	}
+}
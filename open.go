@@ -2,8 +2,10 @@ package cete
 
 import (
 	"errors"
-	"log"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,7 +16,17 @@ import (
 )
 
 type indexConfig struct {
-	IndexName string
+	IndexName  string
+	Query      string
+	Func       bool
+	Filtered   bool
+	Stale      bool
+	Collation  Collation
+	Encoding   int32
+	Unique     bool
+	Generation int
+	Building   bool
+	Checkpoint string
 }
 
 type tableConfig struct {
@@ -23,6 +35,7 @@ type tableConfig struct {
 	UseKeyCompression bool
 	KeyCompression    map[string]string
 	NextKey           string
+	DefaultTTL        time.Duration
 }
 
 type dbConfig struct {
@@ -38,7 +51,44 @@ func (d *DB) newKV(names ...Name) (*badger.KV, error) {
 
 	dir += "/data"
 
+	return d.openKV(dir)
+}
+
+// indexPath returns the directory of the given generation of an index. The
+// generation of an index is incremented every time it is rebuilt.
+func (d *DB) indexPath(tableName, indexName string, generation int) string {
+	dir := d.path + "/" + Name(tableName).Hex() + "/" + Name(indexName).Hex() +
+		"/data"
+	if generation > 0 {
+		dir += "-" + strconv.Itoa(generation)
+	}
+
+	return dir
+}
+
+// removeStaleGenerations removes the directories of previous generations
+// of an index, which may be left behind if the database was not closed
+// after the index was rebuilt.
+func removeStaleGenerations(dir string) {
+	parent := filepath.Dir(dir)
+	entries, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != filepath.Base(dir) {
+			os.RemoveAll(parent + "/" + entry.Name())
+		}
+	}
+}
+
+func (d *DB) openKV(dir string) (*badger.KV, error) {
 	if found, _ := exists(dir); !found {
+		if d.readOnly {
+			return nil, errors.New("cete: store not found: " + dir)
+		}
+
 		if err := os.MkdirAll(dir, 0744); err != nil {
 			return nil, err
 		}
@@ -53,16 +103,25 @@ func (d *DB) newKV(names ...Name) (*badger.KV, error) {
 		return nil, err
 	}
 
+	if d.readOnly {
+		return kv, nil
+	}
+
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
-				log.Println("cete: gc panic:", r)
+				d.log("cete: gc panic:", r)
 			}
 		}()
 
 		for atomic.LoadInt32(&d.closed) == 0 {
 			kv.RunValueLogGC(0.2)
-			time.Sleep(time.Second * 10)
+
+			select {
+			case <-d.done:
+				return
+			case <-time.After(time.Second * 10):
+			}
 		}
 	}()
 	return kv, nil
@@ -70,7 +129,33 @@ func (d *DB) newKV(names ...Name) (*badger.KV, error) {
 
 // Open opens the database at the provided path. It will create a new
 // database if the folder does not exist.
+//
+// Indexes created by older versions of cete are rebuilt with the current
+// encoding in the background once the database is opened, and are used with
+// their older encoding until then. Like with Index.Rebuild, writes are
+// blocked while each index is being rebuilt.
 func Open(path string, opts ...badger.Options) (*DB, error) {
+	return open(path, false, opts)
+}
+
+// OpenReadOnly opens the existing database at the provided path without
+// changing its documents or indexes. Writes to the database return
+// ErrReadOnly, and unlike Open, writes which were interrupted the last time
+// the database was used are not finished, indexes are not migrated or built,
+// and expired documents are not deleted. Indexes which were still being
+// built return ErrIndexBuilding. Missing files, such as the journal of a
+// database created by an older version of cete, are not created.
+//
+// Badger has no read-only mode, so the underlying stores are still opened
+// for writing. Opening them takes their lock files, and closing them may
+// write Badger's own files, so the files of the database can change, and it
+// must not be on a read-only file system. The database must not be open by
+// another process.
+func OpenReadOnly(path string, opts ...badger.Options) (*DB, error) {
+	return open(path, true, opts)
+}
+
+func open(path string, readOnly bool, opts []badger.Options) (*DB, error) {
 	defaultOpts := badger.DefaultOptions
 	defaultOpts.TableLoadingMode = options.MemoryMap
 
@@ -79,18 +164,33 @@ func Open(path string, opts ...badger.Options) (*DB, error) {
 		tables:      make(map[Name]*Table),
 		configMutex: new(sync.Mutex),
 		openOptions: defaultOpts,
+		commitLock:  new(sync.RWMutex),
+		readOnly:    readOnly,
+		done:        make(chan struct{}),
 	}
 
 	if len(opts) > 0 {
 		db.openOptions = opts[0]
 	}
 
+	var err error
+
 	if ex, _ := exists(path); !ex {
-		if err := os.MkdirAll(path, 0744); err != nil {
+		if readOnly {
+			return nil, errors.New("cete: database not found: " + path)
+		}
+
+		if err = os.MkdirAll(path, 0744); err != nil {
 			return nil, errors.New("cete: failed to create database: " +
 				err.Error())
 		}
 
+		db.journal, err = db.openKV(path + "/journal")
+		if err != nil {
+			return nil, errors.New("cete: failed to open journal: " +
+				err.Error())
+		}
+
 		return db, nil
 	}
 
@@ -112,11 +212,49 @@ func Open(path string, opts ...badger.Options) (*DB, error) {
 	db.config = config
 
 	for _, table := range config.Tables {
-		tb := &Table{indexes: make(map[Name]*Index)}
+		tb := &Table{
+			indexes:    make(map[Name]*Index),
+			uniqueLock: new(sync.Mutex),
+			defaultTTL: int64(table.DefaultTTL),
+			watchLock:  new(sync.Mutex),
+			watchers:   make(map[*watcher]bool),
+			keyLocks:   newKeyLocks(),
+		}
 		for _, index := range table.Indexes {
-			idx := &Index{}
+			idx := &Index{
+				query:    index.Query,
+				unique:   index.Unique,
+				lock:     new(sync.RWMutex),
+				function: index.Func,
+				filtered: index.Filtered,
+				stale:    index.Stale,
+				encoding: index.Encoding,
+			}
 
-			idx.index, err = db.newKV(Name(table.TableName), Name(index.IndexName))
+			idx.collator, err = newCollator(index.Collation)
+			if err != nil {
+				return nil, errors.New("cete: failed to open " +
+					table.TableName + "/" +
+					index.IndexName + ": " + err.Error())
+			}
+
+			// Indexes created before queries were configurable use their
+			// name as the query.
+			if idx.query == "" && !idx.function {
+				idx.query = index.IndexName
+			}
+
+			if index.Building {
+				idx.build = newIndexBuild(index.Checkpoint)
+			}
+
+			dir := db.indexPath(table.TableName, index.IndexName,
+				index.Generation)
+			if !readOnly {
+				removeStaleGenerations(dir)
+			}
+
+			idx.index, err = db.openKV(dir)
 			if err != nil {
 				return nil, errors.New("cete: failed to open " +
 					table.TableName + "/" +
@@ -152,6 +290,43 @@ func Open(path string, opts ...badger.Options) (*DB, error) {
 		db.tables[Name(table.TableName)] = tb
 	}
 
+	if found, _ := exists(path + "/journal"); readOnly && !found {
+		// Databases created by older versions don't have a journal.
+		return db, nil
+	}
+
+	db.journal, err = db.openKV(path + "/journal")
+	if err != nil {
+		return nil, errors.New("cete: failed to open journal: " +
+			err.Error())
+	}
+
+	if readOnly {
+		return db, nil
+	}
+
+	if err = db.recoverJournal(); err != nil {
+		return nil, errors.New("cete: failed to recover journal: " +
+			err.Error())
+	}
+
+	if err = db.migrateIndexes(); err != nil {
+		return nil, errors.New("cete: failed to migrate indexes: " +
+			err.Error())
+	}
+
+	for _, table := range db.tables {
+		go table.runReaper()
+
+		for name, index := range table.indexes {
+			// The builds of indexes with a function or filter are
+			// resumed when they are registered.
+			if index.build != nil && !index.unregistered() {
+				go index.runBuild(string(name))
+			}
+		}
+	}
+
 	return db, nil
 }
 
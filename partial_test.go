@@ -0,0 +1,103 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type partialOrder struct {
+	Status string
+	Total  int
+}
+
+func activeOrder(doc Document) bool {
+	return doc.QueryString("Status") == "active"
+}
+
+func TestPartialIndex(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("partial_testing"))
+	table := db.Table("partial_testing")
+
+	panicNotNil(table.Set("a", partialOrder{Status: "active", Total: 10}))
+	panicNotNil(table.Set("b", partialOrder{Status: "shipped", Total: 20}))
+	panicNotNil(table.Set("c", partialOrder{Status: "active", Total: 30}))
+
+	panicNotNil(table.NewIndex("Total", IndexOptions{
+		Filter: activeOrder,
+	}))
+
+	if count := table.Index("Total").CountBetween(MinValue, MaxValue); count != 2 {
+		t.Fatal("index should have 2 documents, but has", count)
+	}
+
+	panicNotNil(table.Set("a", partialOrder{Status: "shipped", Total: 10}))
+	panicNotNil(table.Set("d", partialOrder{Status: "active", Total: 40}))
+	panicNotNil(table.Set("e", partialOrder{Status: "cancelled", Total: 50}))
+
+	var totals []int
+	r := table.Index("Total").All()
+	for r.Next() {
+		totals = append(totals, r.Document().QueryInt("Total"))
+	}
+
+	if len(totals) != 2 || totals[0] != 30 || totals[1] != 40 {
+		t.Fatal("totals should be [30 40], but are", totals)
+	}
+
+	report, err := table.Index("Total").Verify()
+	panicNotNil(err)
+	if !report.OK() {
+		t.Fatalf("index should be consistent, but has %+v", report)
+	}
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("partial_testing")
+
+	err = table.Set("b", partialOrder{Status: "active", Total: 20})
+	if err != ErrIndexFuncNotRegistered {
+		t.Fatal("error should be ErrIndexFuncNotRegistered, but is", err)
+	}
+
+	if err = table.NewIndex("Total"); err != ErrAlreadyExists {
+		t.Fatal("error should be ErrAlreadyExists, but is", err)
+	}
+
+	panicNotNil(table.NewIndex("Total", IndexOptions{
+		Filter: activeOrder,
+	}))
+	panicNotNil(table.Set("b", partialOrder{Status: "active", Total: 20}))
+
+	if count := table.Index("Total").CountBetween(MinValue, MaxValue); count != 3 {
+		t.Fatal("index should have 3 documents, but has", count)
+	}
+
+	if err = table.NewIndex("Total", IndexOptions{
+		Filter: activeOrder,
+	}); err != ErrAlreadyExists {
+		t.Fatal("error should be ErrAlreadyExists, but is", err)
+	}
+}
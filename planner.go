@@ -0,0 +1,918 @@
+package cete
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1lann/badger"
+	"github.com/1lann/msgpack"
+)
+
+// Operator represents the comparison operator of a query's predicate.
+type Operator int
+
+// Comparison operators. In matches documents which have a value equal to any
+// of the values in the slice it is given, and Ne matches documents which
+// don't have a value equal to the given value.
+const (
+	Eq Operator = iota
+	Ne
+	Lt
+	Lte
+	Gt
+	Gte
+	In
+)
+
+// String returns the symbol of the operator.
+func (o Operator) String() string {
+	switch o {
+	case Eq:
+		return "="
+	case Ne:
+		return "!="
+	case Lt:
+		return "<"
+	case Lte:
+		return "<="
+	case Gt:
+		return ">"
+	case Gte:
+		return ">="
+	case In:
+		return "in"
+	}
+
+	return "Operator(" + strconv.Itoa(int(o)) + ")"
+}
+
+// maxIntersectRatio is how many times more index entries than the chosen
+// index another index may have for it to be intersected with the chosen
+// index.
+const maxIntersectRatio = 8
+
+// maxEstimate is the number of index entries that are counted to estimate
+// the cost of an index path. Paths with more entries than it are assumed to
+// have maxEstimate + 1 entries, so planning a query doesn't read all of a
+// large index.
+const maxEstimate = 10000
+
+// Query represents a query on a table. Create one with Table.Query, add
+// predicates with Where, and run it with Run.
+type Query struct {
+	table      *Table
+	predicates []predicate
+	orderBy    string
+	reverse    bool
+	limit      int64
+}
+
+type predicate struct {
+	field string
+	op    Operator
+	value interface{}
+}
+
+// Query returns a new query on the table. The query picks which of the
+// table's indexes to use when it is run, intersecting the results of
+// multiple indexes where useful, and scans the table if none of them can be
+// used. Function indexes and partial indexes are never used by queries.
+//
+// Values are compared the same way as they are ordered by indexes, so
+// strings are case-insensitive unless the table has an index with a
+// different Collation on the field.
+func (t *Table) Query() *Query {
+	return &Query{table: t}
+}
+
+// Where adds a predicate to the query, which only matches documents that
+// have a value of the field, in the same format as an index's Query, which
+// compares to the given value with the operator. A field with multiple
+// values, such as "Likes.*", matches if any of its values do. Predicates
+// are combined with each other, so all of them must match.
+func (q *Query) Where(field string, op Operator, value interface{}) *Query {
+	q.predicates = append(q.predicates, predicate{field, op, value})
+	return q
+}
+
+// OrderBy sorts the results of the query by the field, in descending order
+// if reverse is true. Documents which don't have a value of the field are
+// not returned. If the field has multiple values, the documents are sorted
+// by their lowest value, or highest value in descending order.
+//
+// By default, the results are in the order of the index chosen to run the
+// query, or the order of their keys if the table is scanned.
+func (q *Query) OrderBy(field string, reverse ...bool) *Query {
+	q.orderBy = field
+	q.reverse = len(reverse) > 0 && reverse[0]
+	return q
+}
+
+// Limit limits the number of documents returned by the query.
+func (q *Query) Limit(n int64) *Query {
+	q.limit = n
+	return q
+}
+
+// Run runs the query and returns its results.
+func (q *Query) Run() *Range {
+	return q.RunCtx(context.Background())
+}
+
+// RunCtx is like Run, but the range is closed and returns ctx.Err() when
+// the context is done.
+func (q *Query) RunCtx(ctx context.Context) *Range {
+	p, err := q.plan()
+	if err != nil {
+		return newRange(func() (string, []byte, uint64, error) {
+			return "", nil, 0, err
+		}, func() {}, nil)
+	}
+
+	return p.run(ctx)
+}
+
+// Explain returns a description of the plan that would be used to run the
+// query, with one step per line, such as the indexes used and the estimated
+// number of index entries read from each of them. To keep planning cheap,
+// estimates stop counting at 10000 entries.
+func (q *Query) Explain() string {
+	p, err := q.plan()
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	return strings.Join(p.steps, "\n")
+}
+
+// keyRange is an inclusive range of index keys, where a nil bound is
+// unbounded. Unless exact is true, keys which start with the upper bound are
+// also within the range, so a range on the leading components of a compound
+// index includes every value of its remaining components.
+type keyRange struct {
+	lower []byte
+	upper []byte
+	exact bool
+}
+
+func (r keyRange) aboveUpper(key []byte) bool {
+	return r.upper != nil && bytes.Compare(key, r.upper) > 0 &&
+		(r.exact || !bytes.HasPrefix(key, r.upper))
+}
+
+// prefixEnd returns the smallest key which is greater than all of the keys
+// starting with prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+func concat(a, b []byte) []byte {
+	result := make([]byte, len(a)+len(b))
+	copy(result, a)
+	copy(result[len(a):], b)
+	return result
+}
+
+// scanRanges calls fn with every index key within the ranges and its value,
+// in the order of the index, until fn returns false.
+func (i *Index) scanRanges(ranges []keyRange, reverse bool,
+	fn func(key, value []byte) bool) {
+	itOpts := badger.DefaultIteratorOptions
+	itOpts.PrefetchSize = prefetchSize
+	itOpts.Reverse = reverse
+	it := i.kv().NewIterator(itOpts)
+	defer it.Close()
+
+	for n := range ranges {
+		r := ranges[n]
+		if reverse {
+			r = ranges[len(ranges)-1-n]
+		}
+
+		if !reverse {
+			if r.lower == nil {
+				it.Rewind()
+			} else {
+				it.Seek(r.lower)
+			}
+		} else if r.upper == nil {
+			it.Rewind()
+		} else if r.exact {
+			it.Seek(r.upper)
+		} else if end := prefixEnd(r.upper); end == nil {
+			it.Rewind()
+		} else {
+			it.Seek(end)
+		}
+
+		for ; it.Valid(); it.Next() {
+			key := it.Item().Key()
+			if r.aboveUpper(key) {
+				if reverse {
+					continue
+				}
+				break
+			}
+
+			if reverse && r.lower != nil && bytes.Compare(key, r.lower) < 0 {
+				break
+			}
+
+			if !fn(key, getItemValue(it.Item())) {
+				return
+			}
+		}
+	}
+}
+
+// countRanges returns the number of index entries within the ranges.
+func (i *Index) countRanges(ranges []keyRange) int64 {
+	var count int64
+	i.scanRanges(ranges, false, func(key, value []byte) bool {
+		if len(value) > 0 {
+			count += decodeArrayCount(value)
+		}
+		return true
+	})
+
+	return count
+}
+
+// estimateRanges returns the number of index entries within the ranges, or
+// maxEstimate + 1 if there are more than maxEstimate.
+func (i *Index) estimateRanges(ranges []keyRange) int64 {
+	var count int64
+	i.scanRanges(ranges, false, func(key, value []byte) bool {
+		if len(value) > 0 {
+			count += decodeArrayCount(value)
+		}
+		return count <= maxEstimate
+	})
+
+	if count > maxEstimate {
+		return maxEstimate + 1
+	}
+
+	return count
+}
+
+// keysInRanges returns the keys of the documents within the ranges, in the
+// order of the index, without duplicates. ctx.Err() is returned if the
+// context is done before all of the keys are read.
+func (i *Index) keysInRanges(ctx context.Context, ranges []keyRange,
+	reverse bool) ([]string, error) {
+	var results []string
+	var err error
+	seen := make(map[string]bool)
+
+	i.scanRanges(ranges, reverse, func(_, value []byte) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+
+		var keys []string
+		if err = msgpack.Unmarshal(value, &keys); err != nil {
+			err = i.indexError("", err)
+			return false
+		}
+
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				results = append(results, key)
+			}
+		}
+
+		return true
+	})
+
+	return results, err
+}
+
+// check is a predicate of a query with its values encoded, which is checked
+// against every document the query reads. Values are always encoded with the
+// current encoding, which orders all numbers by their value, so integers and
+// floats are compared numerically even if the index of the field still uses
+// an older encoding.
+type check struct {
+	predicate
+	raw    []interface{}
+	values [][]byte
+	encode func(value interface{}) ([]byte, error)
+}
+
+func (c check) matches(doc Document) bool {
+	for _, value := range doc.QueryAll(c.field) {
+		b, err := c.encode(value)
+		if err != nil {
+			continue
+		}
+
+		if c.op == Ne {
+			if bytes.Equal(b, c.values[0]) {
+				return false
+			}
+			continue
+		}
+
+		if c.compare(b) {
+			return true
+		}
+	}
+
+	return c.op == Ne
+}
+
+func (c check) compare(b []byte) bool {
+	switch c.op {
+	case Eq:
+		return bytes.Equal(b, c.values[0])
+	case Lt:
+		return bytes.Compare(b, c.values[0]) < 0
+	case Lte:
+		return bytes.Compare(b, c.values[0]) <= 0
+	case Gt:
+		return bytes.Compare(b, c.values[0]) > 0
+	case Gte:
+		return bytes.Compare(b, c.values[0]) >= 0
+	case In:
+		for _, value := range c.values {
+			if bytes.Equal(b, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// indexPath is a way of finding the documents which may match a query with
+// the ranges of an index.
+type indexPath struct {
+	index  *Index
+	fields []string
+	ranges []keyRange
+	cost   int64
+
+	// order is the field which the documents are ordered by when the
+	// ranges are read in order.
+	order string
+}
+
+func (p *indexPath) String() string {
+	cost := strconv.FormatInt(p.cost, 10)
+	if p.cost > maxEstimate {
+		cost = "over " + strconv.Itoa(maxEstimate)
+	}
+
+	return "index \"" + p.index.indexName() + "\" on " +
+		strings.Join(p.fields, ", ") + " (" + cost + " entries)"
+}
+
+// queryPlan is how a query is run. The documents are read from the primary
+// path, intersected with the keys of the other paths, or from the table if
+// there are no paths.
+type queryPlan struct {
+	query   *Query
+	paths   []*indexPath
+	scan    *Index
+	checks  []check
+	sortBy  *check
+	ordered bool
+	steps   []string
+}
+
+// collationOf returns the collation of an index.
+func collationOf(i *Index) Collation {
+	if i == nil || i.collator == nil {
+		return Collation{}
+	}
+
+	return i.collator.collation
+}
+
+// usable returns true if the index can be used by queries.
+func (i *Index) usable() bool {
+	i.table.db.commitLock.RLock()
+	stale := i.stale
+	i.table.db.commitLock.RUnlock()
+
+	return !i.function && !i.filtered && !stale && !i.building()
+}
+
+// fieldIndexes returns the index which determines the collation of each
+// field of the query. Indexes on only the field are preferred over compound
+// indexes which include it. Fields without an index aren't included.
+func (q *Query) fieldIndexes(names []string) map[string]*Index {
+	owners := make(map[string]*Index)
+
+	for _, compound := range []bool{false, true} {
+		for _, name := range names {
+			index := q.table.indexes[Name(name)]
+			if index.function {
+				continue
+			}
+
+			components := strings.Split(index.query, ",")
+			if (len(components) > 1) != compound {
+				continue
+			}
+
+			for _, component := range components {
+				if owners[component] == nil {
+					owners[component] = index
+				}
+			}
+		}
+	}
+
+	return owners
+}
+
+func (q *Query) plan() (*queryPlan, error) {
+	p := &queryPlan{query: q}
+
+	names := make([]string, 0, len(q.table.indexes))
+	for name := range q.table.indexes {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	owners := q.fieldIndexes(names)
+	encoder := func(field string) func(interface{}) ([]byte, error) {
+		encodeString := lowerString
+		if owner := owners[field]; owner != nil && owner.collator != nil {
+			encodeString = owner.collator.key
+		}
+
+		return func(value interface{}) ([]byte, error) {
+			return encodeValue(value, encodeString, currentEncoding)
+		}
+	}
+
+	for _, pred := range q.predicates {
+		c := check{predicate: pred, encode: encoder(pred.field)}
+
+		values := []interface{}{pred.value}
+		if pred.op == In {
+			v := reflect.ValueOf(pred.value)
+			if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+				return nil, errors.New("cete: value of In must be a slice")
+			}
+
+			values = make([]interface{}, v.Len())
+			for n := range values {
+				values[n] = v.Index(n).Interface()
+			}
+		} else if pred.op < Eq || pred.op > In {
+			return nil, errors.New("cete: invalid operator")
+		}
+
+		c.raw = values
+		for _, value := range values {
+			b, err := c.encode(value)
+			if err != nil {
+				return nil, err
+			}
+			c.values = append(c.values, b)
+		}
+
+		p.checks = append(p.checks, c)
+	}
+
+	if q.orderBy != "" {
+		p.sortBy = &check{
+			predicate: predicate{field: q.orderBy},
+			encode:    encoder(q.orderBy),
+		}
+	}
+
+	var paths []*indexPath
+	for _, name := range names {
+		index := q.table.indexes[Name(name)]
+		if !index.usable() {
+			continue
+		}
+
+		path, err := p.indexPath(index, owners)
+		if err != nil {
+			return nil, err
+		}
+
+		if path != nil {
+			path.cost = index.estimateRanges(path.ranges)
+			paths = append(paths, path)
+		}
+	}
+
+	sort.SliceStable(paths, func(a, b int) bool {
+		if paths[a].cost != paths[b].cost {
+			return paths[a].cost < paths[b].cost
+		}
+
+		aOrdered := q.orderBy != "" && paths[a].order == q.orderBy
+		bOrdered := q.orderBy != "" && paths[b].order == q.orderBy
+		if aOrdered != bOrdered {
+			return aOrdered
+		}
+
+		return len(paths[a].fields) > len(paths[b].fields)
+	})
+
+	if len(paths) > 0 {
+		primary := paths[0]
+		p.paths = append(p.paths, primary)
+		p.steps = append(p.steps, primary.String())
+
+		covered := make(map[string]bool)
+		for _, field := range primary.fields {
+			covered[field] = true
+		}
+
+		for _, path := range paths[1:] {
+			if primary.cost == 0 ||
+				path.cost > primary.cost*maxIntersectRatio {
+				break
+			}
+
+			useful := false
+			for _, field := range path.fields {
+				if !covered[field] {
+					useful = true
+					covered[field] = true
+				}
+			}
+
+			if useful {
+				p.paths = append(p.paths, path)
+				p.steps = append(p.steps, "intersect "+path.String())
+			}
+		}
+
+		p.ordered = q.orderBy != "" && primary.order == q.orderBy
+	} else if q.orderBy != "" {
+		for _, name := range names {
+			index := q.table.indexes[Name(name)]
+			if index.query == q.orderBy && index.usable() &&
+				collationOf(index) == collationOf(owners[q.orderBy]) {
+				p.scan = index
+				p.ordered = true
+				p.steps = append(p.steps, "scan index \""+name+"\" in order")
+				break
+			}
+		}
+	}
+
+	if len(p.paths) == 0 && p.scan == nil {
+		p.steps = append(p.steps, "scan table")
+	}
+
+	if len(p.checks) > 0 {
+		filters := make([]string, len(p.checks))
+		for n, c := range p.checks {
+			filters[n] = fmt.Sprintf("%s %s %v", c.field, c.op, c.value)
+		}
+		p.steps = append(p.steps, "filter "+strings.Join(filters, " and "))
+	}
+
+	if q.orderBy != "" {
+		order := "order by " + q.orderBy
+		if q.reverse {
+			order += " descending"
+		}
+		if p.ordered {
+			order += " using the index"
+		}
+		p.steps = append(p.steps, order)
+	}
+
+	if q.limit > 0 {
+		p.steps = append(p.steps, "limit "+strconv.FormatInt(q.limit, 10))
+	}
+
+	return p, nil
+}
+
+// indexPath returns the path which uses the most leading components of the
+// index, or nil if the query has no predicates on its first component.
+func (p *queryPlan) indexPath(index *Index,
+	owners map[string]*Index) (*indexPath, error) {
+	path := &indexPath{index: index}
+
+	var prefix []byte
+
+	for _, component := range strings.Split(index.query, ",") {
+		if collationOf(index) != collationOf(owners[component]) {
+			break
+		}
+
+		eq, in, lower, upper, err := p.bounds(index, component)
+		if err != nil {
+			return nil, err
+		}
+
+		if eq != nil {
+			prefix = concat(prefix, eq)
+			path.fields = append(path.fields, component)
+			continue
+		}
+
+		if in != nil {
+			for _, value := range in {
+				key := concat(prefix, value)
+				path.ranges = append(path.ranges, keyRange{lower: key, upper: key})
+			}
+			path.fields = append(path.fields, component)
+		} else if lower != nil || upper != nil {
+			r := keyRange{lower: prefix, upper: prefix}
+			if lower != nil {
+				r.lower = concat(prefix, lower)
+			}
+			if upper != nil {
+				r.upper = concat(prefix, upper)
+			}
+			path.ranges = append(path.ranges, r)
+			path.fields = append(path.fields, component)
+		}
+
+		path.order = component
+		break
+	}
+
+	if len(path.fields) == 0 {
+		return nil, nil
+	}
+
+	if len(path.ranges) == 0 {
+		path.ranges = []keyRange{{lower: prefix, upper: prefix}}
+	}
+
+	return path, nil
+}
+
+// bounds returns the index values that the query's predicates on the field
+// constrain it to with the index. eq is the value of an Eq predicate, in is
+// the sorted values of an In predicate, and lower and upper are the
+// narrowest bounds of the other predicates.
+func (p *queryPlan) bounds(index *Index, field string) (eq []byte,
+	in [][]byte, lower, upper []byte, err error) {
+	for _, c := range p.checks {
+		if c.field != field || c.op == Ne {
+			continue
+		}
+
+		values := make([][]byte, len(c.raw))
+		for n, value := range c.raw {
+			if values[n], err = index.valueToBytes(value); err != nil {
+				return nil, nil, nil, nil, err
+			}
+		}
+
+		switch c.op {
+		case Eq:
+			eq = values[0]
+		case In:
+			sort.Slice(values, func(a, b int) bool {
+				return bytes.Compare(values[a], values[b]) < 0
+			})
+			in = values
+		case Gt, Gte:
+			if lower == nil || bytes.Compare(values[0], lower) > 0 {
+				lower = values[0]
+			}
+		case Lt, Lte:
+			if upper == nil || bytes.Compare(values[0], upper) < 0 {
+				upper = values[0]
+			}
+		}
+	}
+
+	return eq, in, lower, upper, nil
+}
+
+func (p *queryPlan) matches(doc Document) bool {
+	for _, c := range p.checks {
+		if !c.matches(doc) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortKey returns the value of a document which it is sorted by, and false
+// if it doesn't have one.
+func (p *queryPlan) sortKey(doc Document) ([]byte, bool) {
+	var result []byte
+	found := false
+
+	for _, value := range doc.QueryAll(p.sortBy.field) {
+		b, err := p.sortBy.encode(value)
+		if err != nil {
+			continue
+		}
+
+		if !found || (!p.query.reverse && bytes.Compare(b, result) < 0) ||
+			(p.query.reverse && bytes.Compare(b, result) > 0) {
+			result = b
+			found = true
+		}
+	}
+
+	return result, found
+}
+
+// keys returns the keys of the documents to read, or nil if the table
+// should be scanned.
+func (p *queryPlan) keys(ctx context.Context) ([]string, error) {
+	reverse := p.ordered && p.query.reverse
+
+	if p.scan != nil {
+		return p.scan.keysInRanges(ctx, []keyRange{{}}, reverse)
+	}
+
+	if len(p.paths) == 0 {
+		return nil, nil
+	}
+
+	keys, err := p.paths[0].index.keysInRanges(ctx, p.paths[0].ranges,
+		reverse)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range p.paths[1:] {
+		other, err := path.index.keysInRanges(ctx, path.ranges, false)
+		if err != nil {
+			return nil, err
+		}
+
+		found := make(map[string]bool, len(other))
+		for _, key := range other {
+			found[key] = true
+		}
+
+		results := keys[:0]
+		for _, key := range keys {
+			if found[key] {
+				results = append(results, key)
+			}
+		}
+		keys = results
+	}
+
+	if keys == nil {
+		keys = []string{}
+	}
+
+	return keys, nil
+}
+
+func (p *queryPlan) run(ctx context.Context) *Range {
+	t := p.query.table
+
+	keys, err := p.keys(ctx)
+	if err != nil {
+		return newRange(func() (string, []byte, uint64, error) {
+			return "", nil, 0, err
+		}, func() {}, nil)
+	}
+
+	var source *Range
+	if keys == nil {
+		source = t.BetweenCtx(ctx, MinValue, MaxValue)
+	} else {
+		source = t.getKeys(ctx, keys)
+	}
+
+	var entries []bufferEntry
+	sorted := p.sortBy == nil || p.ordered
+	n := int64(0)
+
+	next := func() (string, []byte, uint64, error) {
+		for source.Next() {
+			doc := source.Document()
+			if !p.matches(doc) {
+				continue
+			}
+
+			if p.sortBy != nil {
+				if _, found := p.sortKey(doc); !found {
+					continue
+				}
+			}
+
+			return source.Key(), doc.data, source.Counter(), nil
+		}
+
+		return "", nil, 0, source.Error()
+	}
+
+	return newRangeCtx(ctx, func() (string, []byte, uint64, error) {
+		if p.query.limit > 0 && n >= p.query.limit {
+			return "", nil, 0, ErrEndOfRange
+		}
+
+		if !sorted {
+			sorted = true
+
+			var sortKeys [][]byte
+			for {
+				key, data, counter, err := next()
+				if err == ErrEndOfRange {
+					break
+				} else if err != nil {
+					return "", nil, 0, err
+				}
+
+				sortKey, _ := p.sortKey(Document{data: data, table: t})
+				entries = append(entries, bufferEntry{key, data, counter, nil})
+				sortKeys = append(sortKeys, sortKey)
+			}
+
+			indexes := make([]int, len(entries))
+			for i := range indexes {
+				indexes[i] = i
+			}
+
+			sort.SliceStable(indexes, func(a, b int) bool {
+				c := bytes.Compare(sortKeys[indexes[a]], sortKeys[indexes[b]])
+				if p.query.reverse {
+					return c > 0
+				}
+				return c < 0
+			})
+
+			results := make([]bufferEntry, len(entries))
+			for i, index := range indexes {
+				results[i] = entries[index]
+			}
+			entries = results
+
+			next = func() (string, []byte, uint64, error) {
+				if len(entries) == 0 {
+					return "", nil, 0, ErrEndOfRange
+				}
+
+				entry := entries[0]
+				entries = entries[1:]
+				return entry.key, entry.data, entry.counter, nil
+			}
+		}
+
+		key, data, counter, err := next()
+		if err == nil {
+			n++
+		}
+
+		return key, data, counter, err
+	}, source.Close, t)
+}
+
+// getKeys returns a range of the documents with the keys, in order,
+// skipping documents which don't exist or have expired.
+func (t *Table) getKeys(ctx context.Context, keys []string) *Range {
+	c := 0
+	var item badger.KVItem
+
+	return newRangeCtx(ctx, func() (string, []byte, uint64, error) {
+		for {
+			if c >= len(keys) {
+				return "", nil, 0, ErrEndOfRange
+			}
+
+			err := t.data.Get([]byte(keys[c]), &item)
+			if err != nil {
+				return "", nil, 0, err
+			}
+
+			c++
+
+			itemValue := getItemValue(&item)
+			if itemValue == nil || itemExpired(&item) {
+				continue
+			}
+
+			value := make([]byte, len(itemValue))
+			copy(value, itemValue)
+
+			return keys[c-1], value, item.Counter(), nil
+		}
+	}, func() {}, t)
+}
@@ -0,0 +1,184 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestQueryPlanner(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("planner_testing"))
+	table := db.Table("planner_testing")
+
+	people := map[string]Person{
+		"ben":   {Name: "Ben", City: "Melbourne", Age: 19, Likes: []string{"c", "go"}},
+		"drew":  {Name: "Drew", City: "London", Age: 17, Likes: []string{"js"}},
+		"jason": {Name: "Jason", City: "Sydney", Age: 18, Likes: []string{"go", "js"}},
+		"kevin": {Name: "Kevin", City: "Sydney", Age: 25, Likes: []string{"rust"}},
+		"mark":  {Name: "Mark", City: "Sydney", Age: 16, Likes: []string{"go"}},
+	}
+
+	for key, person := range people {
+		panicNotNil(table.Set(key, person))
+	}
+
+	panicNotNil(table.NewIndex("Age"))
+	panicNotNil(table.NewIndex("City,Age"))
+	panicNotNil(table.NewIndex("Likes.*"))
+
+	expectKeys := func(q *Query, expected ...string) {
+		t.Helper()
+
+		var results []string
+		r := q.Run()
+		for r.Next() {
+			results = append(results, r.Key())
+		}
+
+		if r.Error() != ErrEndOfRange {
+			t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+		}
+
+		if strings.Join(results, ",") != strings.Join(expected, ",") {
+			t.Fatal("keys should be", expected, "but are", results)
+		}
+	}
+
+	expectPlan := func(q *Query, step string) {
+		t.Helper()
+
+		if plan := q.Explain(); !strings.Contains(plan, step) {
+			t.Fatalf("plan should contain %q, but is:\n%s", step, plan)
+		}
+	}
+
+	q := table.Query().Where("City", Eq, "sydney").Where("Age", Gte, 18)
+	expectKeys(q, "jason", "kevin")
+	expectPlan(q, "index \"City,Age\" on City, Age (2 entries)")
+
+	q = table.Query().Where("City", Eq, "Sydney").OrderBy("Age", true)
+	expectKeys(q, "kevin", "jason", "mark")
+	expectPlan(q, "order by Age descending using the index")
+
+	q = table.Query().Where("Age", Gt, 16).Where("Age", Lt, 25).OrderBy("Age")
+	expectKeys(q, "drew", "jason", "ben")
+	expectPlan(q, "index \"Age\" on Age")
+
+	q = table.Query().Where("Likes.*", In, []string{"rust", "c"}).
+		OrderBy("Age")
+	expectKeys(q, "ben", "kevin")
+	expectPlan(q, "index \"Likes.*\" on Likes.*")
+	if strings.Contains(q.Explain(), "using the index") {
+		t.Fatal("plan should sort the results, but is:\n" + q.Explain())
+	}
+
+	q = table.Query().Where("City", Eq, "Sydney").Where("Likes.*", Eq, "go").
+		OrderBy("Age")
+	expectKeys(q, "mark", "jason")
+	expectPlan(q, "intersect index")
+
+	q = table.Query().Where("Name", Ne, "ben").OrderBy("Age").Limit(2)
+	expectKeys(q, "mark", "drew")
+	expectPlan(q, "scan index \"Age\" in order")
+	expectPlan(q, "limit 2")
+
+	q = table.Query().Where("Name", Eq, "Kevin")
+	expectKeys(q, "kevin")
+	expectPlan(q, "scan table")
+
+	q = table.Query().Where("City", In, "Sydney")
+	if r := q.Run(); r.Next() || r.Error() == ErrEndOfRange {
+		t.Fatal("query should fail, but has error", r.Error())
+	}
+}
+
+func TestQueryNumbers(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("numbers_testing"))
+	table := db.Table("numbers_testing")
+
+	heights := map[string]float64{
+		"ben":   1.5,
+		"drew":  2,
+		"jason": 0.5,
+		"kevin": -1.5,
+		"mark":  3.25,
+	}
+
+	for key, height := range heights {
+		panicNotNil(table.Set(key, Person{Height: height}))
+	}
+
+	expectKeys := func(q *Query, expected ...string) {
+		t.Helper()
+
+		var results []string
+		r := q.Run()
+		for r.Next() {
+			results = append(results, r.Key())
+		}
+
+		if r.Error() != ErrEndOfRange {
+			t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+		}
+
+		if strings.Join(results, ",") != strings.Join(expected, ",") {
+			t.Fatal("keys should be", expected, "but are", results)
+		}
+	}
+
+	// The queries are run by scanning the table, then with an index.
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			panicNotNil(table.NewIndex("Height"))
+		}
+
+		expectKeys(table.Query().Where("Height", Gt, 1).OrderBy("Height"),
+			"ben", "drew", "mark")
+		expectKeys(table.Query().Where("Height", Lte, int8(2)).
+			Where("Height", Gte, -1).OrderBy("Height"), "jason", "ben", "drew")
+		expectKeys(table.Query().Where("Height", Lt, uint16(0)), "kevin")
+		expectKeys(table.Query().Where("Height", Eq, 2), "drew")
+		expectKeys(table.Query().Where("Height", In, []int{-1, 2, 3}), "drew")
+		expectKeys(table.Query().Where("Height", Ne, int64(2)).
+			OrderBy("Height", true), "mark", "ben", "jason", "kevin")
+	}
+}
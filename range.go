@@ -1,7 +1,9 @@
 package cete
 
 import (
+	"context"
 	"errors"
+	"iter"
 	"reflect"
 	"sync"
 	"sync/atomic"
@@ -25,10 +27,16 @@ type Range struct {
 	next   func() (string, []byte, uint64, error)
 	close  func()
 	closed int32
+	ctx    context.Context
+	start  *sync.Once
 
 	lastEntry bufferEntry
 
 	table *Table
+
+	// keys, if set, returns the keys of the documents in the range without
+	// reading them, which is used by Intersect, Union and Except.
+	keys func() ([]string, error)
 }
 
 // Next retrieves the next item in the range, and returns true if the
@@ -38,7 +46,12 @@ func (r *Range) Next() bool {
 		return false
 	}
 
-	entry, more := <-r.buffer
+	if err := r.ctx.Err(); err != nil {
+		r.lastEntry = bufferEntry{err: err}
+		return false
+	}
+
+	entry, more := <-r.entries()
 	if !more {
 		r.lastEntry.err = ErrEndOfRange
 		return false
@@ -87,6 +100,75 @@ func (r *Range) Error() error {
 	return r.lastEntry.err
 }
 
+// Seq2 returns an iterator over the keys and documents of the range, to be
+// used with a for range loop. The range is closed when the loop ends, and
+// Error can be used afterwards to check if the loop was stopped by an error.
+// Like Next, Error returns ErrEndOfRange if there was no error, including
+// if the loop was broken out of early.
+//
+// If the range hasn't been read from yet, the iterator reads values directly
+// from the database, without buffering them in the background.
+func (r *Range) Seq2() iter.Seq2[string, Document] {
+	return func(yield func(string, Document) bool) {
+		direct := false
+		r.start.Do(func() {
+			direct = true
+		})
+
+		if !direct {
+			for r.Next() {
+				if !yield(r.Key(), r.Document()) {
+					r.stop()
+					return
+				}
+			}
+			return
+		}
+
+		defer func() {
+			r.Close()
+			r.buffer <- r.lastEntry
+			close(r.buffer)
+		}()
+
+		for {
+			if err := r.ctx.Err(); err != nil {
+				r.lastEntry = bufferEntry{err: err}
+				return
+			}
+
+			key, data, counter, err := r.next()
+			r.lastEntry = bufferEntry{key, data, counter, err}
+			if err != nil {
+				return
+			}
+
+			if !yield(key, r.Document()) {
+				r.lastEntry = bufferEntry{err: ErrEndOfRange}
+				return
+			}
+		}
+	}
+}
+
+// Seq returns an iterator over the documents of the range. See Seq2 for
+// details.
+func (r *Range) Seq() iter.Seq[Document] {
+	return func(yield func(Document) bool) {
+		for _, doc := range r.Seq2() {
+			if !yield(doc) {
+				return
+			}
+		}
+	}
+}
+
+// stop closes the range after it has been partially read by Next.
+func (r *Range) stop() {
+	r.Close()
+	r.lastEntry = bufferEntry{err: ErrEndOfRange}
+}
+
 // All stores all of the results into slice dst provided by as a pointer.
 // A nil error will be returned if the range reaches ErrEndOfRange.
 func (r *Range) All(dst interface{}) error {
@@ -109,7 +191,7 @@ func (r *Range) All(dst interface{}) error {
 
 	var err error
 	for {
-		entry, more := <-r.buffer
+		entry, more := <-r.entries()
 		if !more {
 			return nil
 		}
@@ -155,7 +237,7 @@ func (r *Range) All(dst interface{}) error {
 // When this limit is reached, ErrEndOfRange will be returned.
 func (r *Range) Limit(n int64) *Range {
 	return newRange(func() (string, []byte, uint64, error) {
-		entry := <-r.buffer
+		entry := <-r.entries()
 
 		if n <= 0 {
 			return "", nil, 0, ErrEndOfRange
@@ -176,29 +258,78 @@ func (r *Range) Close() {
 
 func newRange(next func() (string, []byte, uint64, error), closer func(),
 	table *Table) *Range {
-	r := &Range{
+	return newRangeCtx(context.Background(), next, closer, table)
+}
+
+// newRangeCtx returns a range which stops reading values and closes itself
+// when the context is done, after which the context's error is returned
+// from the range.
+func newRangeCtx(ctx context.Context, next func() (string, []byte, uint64,
+	error), closer func(), table *Table) *Range {
+	return &Range{
 		buffer: make(chan bufferEntry, bufferSize),
 		next:   next,
 		close:  closer,
 		table:  table,
+		ctx:    ctx,
+		start:  new(sync.Once),
 	}
+}
 
-	go func() {
-		for {
-			key, data, counter, err := r.next()
-			// r.Close before sending to channel to prevent race condition
-			if err != nil {
-				r.Close()
-			}
-			r.buffer <- bufferEntry{key, data, counter, err}
-			if err != nil {
-				close(r.buffer)
-				return
-			}
+// entries returns the buffer of the range, starting the goroutine which
+// reads values into it if it hasn't already been started.
+func (r *Range) entries() chan bufferEntry {
+	r.start.Do(func() {
+		go r.produce()
+	})
+
+	return r.buffer
+}
+
+func (r *Range) produce() {
+	for {
+		if r.ctx.Err() != nil {
+			r.cancel()
+			return
 		}
-	}()
 
-	return r
+		key, data, counter, err := r.next()
+		// r.Close before sending to channel to prevent race condition
+		if err != nil {
+			r.Close()
+		}
+
+		select {
+		case r.buffer <- bufferEntry{key, data, counter, err}:
+		case <-r.ctx.Done():
+			r.cancel()
+			return
+		}
+
+		if err != nil {
+			close(r.buffer)
+			return
+		}
+	}
+}
+
+// cancel closes the range, and replaces any buffered values with the
+// context's error, so that the range's goroutine never blocks on a reader
+// which has gone away.
+func (r *Range) cancel() {
+	r.Close()
+
+drain:
+	for {
+		select {
+		case <-r.buffer:
+		default:
+			break drain
+		}
+	}
+
+	r.buffer <- bufferEntry{err: r.ctx.Err()}
+	close(r.buffer)
 }
 
 // Filter applies a filter onto the range, skipping values where the provided
@@ -212,6 +343,13 @@ func newRange(next func() (string, []byte, uint64, error), closer func(),
 // is 5.
 func (r *Range) Filter(filter func(doc Document) (bool, error),
 	workers ...int) *Range {
+	return r.FilterCtx(context.Background(), filter, workers...)
+}
+
+// FilterCtx is like Filter, but stops filtering and returns ctx.Err() from
+// the resulting range when the context is done.
+func (r *Range) FilterCtx(ctx context.Context,
+	filter func(doc Document) (bool, error), workers ...int) *Range {
 
 	numWorkers := 5
 	if len(workers) > 0 && workers[0] != 0 {
@@ -223,33 +361,22 @@ func (r *Range) Filter(filter func(doc Document) (bool, error),
 	for i := range inboxes {
 		inboxes[i] = make(chan *bufferEntry)
 		outboxes[i] = make(chan *bufferEntry)
-		go filterWorker(filter, r.table, inboxes[i], outboxes[i])
+		go filterWorker(ctx, filter, r.table, inboxes[i], outboxes[i])
 	}
 
-	go func() {
-		sendToWorker := 0
-
-		for {
-			entry, more := <-r.buffer
-			if !more {
-				break
-			}
-
-			inboxes[sendToWorker] <- &entry
-			sendToWorker = (sendToWorker + 1) % numWorkers
-		}
-
-		for _, inbox := range inboxes {
-			close(inbox)
-		}
-	}()
+	go r.distribute(ctx, inboxes)
 
 	readFromWorker := 0
 	var entry *bufferEntry
 
-	return newRange(func() (string, []byte, uint64, error) {
+	return newRangeCtx(ctx, func() (string, []byte, uint64, error) {
 		for {
-			entry = <-outboxes[readFromWorker]
+			select {
+			case entry = <-outboxes[readFromWorker]:
+			case <-ctx.Done():
+				return "", nil, 0, ctx.Err()
+			}
+
 			readFromWorker = (readFromWorker + 1) % numWorkers
 			if entry.key == "" && entry.err == nil {
 				continue
@@ -264,7 +391,42 @@ func (r *Range) Filter(filter func(doc Document) (bool, error),
 	}, r.Close, r.table)
 }
 
-func filterWorker(filter func(doc Document) (bool, error),
+// distribute sends the values of the range to the inboxes in turn, until
+// the range ends or the context is done, after which the inboxes are closed.
+func (r *Range) distribute(ctx context.Context, inboxes []chan *bufferEntry) {
+	sendToWorker := 0
+
+	defer func() {
+		for _, inbox := range inboxes {
+			close(inbox)
+		}
+	}()
+
+	for {
+		var entry bufferEntry
+		var more bool
+
+		select {
+		case entry, more = <-r.entries():
+		case <-ctx.Done():
+			return
+		}
+
+		if !more {
+			return
+		}
+
+		select {
+		case inboxes[sendToWorker] <- &entry:
+		case <-ctx.Done():
+			return
+		}
+
+		sendToWorker = (sendToWorker + 1) % len(inboxes)
+	}
+}
+
+func filterWorker(ctx context.Context, filter func(doc Document) (bool, error),
 	table *Table, inbox chan *bufferEntry, outbox chan *bufferEntry) {
 	var entry *bufferEntry
 	var ok bool
@@ -277,26 +439,23 @@ func filterWorker(filter func(doc Document) (bool, error),
 			return
 		}
 
-		if entry.err != nil {
-			outbox <- entry
-			continue
-		}
-
-		ok, err = filter(Document{
-			data:  entry.data,
-			table: table,
-		})
-		if err != nil {
-			entry.err = err
-			outbox <- entry
-			continue
+		if entry.err == nil {
+			ok, err = filter(Document{
+				data:  entry.data,
+				table: table,
+			})
+			if err != nil {
+				entry.err = err
+			} else if !ok {
+				entry.key = ""
+			}
 		}
 
-		if !ok {
-			entry.key = ""
+		select {
+		case outbox <- entry:
+		case <-ctx.Done():
+			return
 		}
-
-		outbox <- entry
 	}
 }
 
@@ -311,6 +470,15 @@ func filterWorker(filter func(doc Document) (bool, error),
 // on. By default the number of workers is 10.
 func (r *Range) Do(operation func(key string, counter uint64, doc Document) error,
 	workers ...int) error {
+	return r.DoCtx(context.Background(), operation, workers...)
+}
+
+// DoCtx is like Do, but stops and returns ctx.Err() when the context is
+// done. Operations which are already running are waited for before DoCtx
+// returns. To also stop reading from the database, create the range with
+// the same context, such as with BetweenCtx.
+func (r *Range) DoCtx(ctx context.Context, operation func(key string,
+	counter uint64, doc Document) error, workers ...int) error {
 
 	numWorkers := 10
 	if len(workers) > 0 && workers[0] != 0 {
@@ -327,25 +495,14 @@ func (r *Range) Do(operation func(key string, counter uint64, doc Document) erro
 		go doWorker(wg, operation, r.table, inboxes[i], completion)
 	}
 
-	go func() {
-		sendToWorker := 0
-
-		for {
-			entry, more := <-r.buffer
-			if !more {
-				break
-			}
-
-			inboxes[sendToWorker] <- &entry
-			sendToWorker = (sendToWorker + 1) % numWorkers
-		}
-
-		for _, inbox := range inboxes {
-			close(inbox)
-		}
-	}()
+	go r.distribute(ctx, inboxes)
 
-	result := <-completion
+	var result error
+	select {
+	case result = <-completion:
+	case <-ctx.Done():
+		result = ctx.Err()
+	}
 
 	if result == nil {
 		r.Close()
@@ -410,7 +567,7 @@ func doWorker(wg *sync.WaitGroup, operation func(key string, counter uint64,
 func (r *Range) Skip(n int) *Range {
 	var entry bufferEntry
 	for i := 0; i < n; i++ {
-		entry = <-r.buffer
+		entry = <-r.entries()
 		if entry.err != nil {
 			return newRange(func() (string, []byte, uint64, error) {
 				return "", nil, 0, entry.err
@@ -430,7 +587,7 @@ func (r *Range) Count() (int64, error) {
 	var entry bufferEntry
 
 	for {
-		entry = <-r.buffer
+		entry = <-r.entries()
 		if entry.err != nil {
 			if entry.err == ErrEndOfRange {
 				return count, nil
@@ -452,7 +609,7 @@ func (r *Range) Unique() *Range {
 
 	return newRange(func() (string, []byte, uint64, error) {
 		for {
-			entry = <-r.buffer
+			entry = <-r.entries()
 
 			if entry.err != nil {
 				return entry.key, entry.data, entry.counter, entry.err
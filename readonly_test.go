@@ -0,0 +1,137 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1lann/msgpack"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	if _, err = OpenReadOnly(dir + "/data"); err == nil {
+		t.Fatal("error should not be nil for a database that doesn't exist")
+	}
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("read_only_testing"))
+	table := db.Table("read_only_testing")
+	panicNotNil(table.NewIndex("Age"))
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", Age: 18}))
+	panicNotNil(table.SetWithTTL("ben", Person{Name: "Ben", Age: 19},
+		time.Millisecond))
+
+	// Leave a committed transaction to be finished in the journal.
+	jason18, err := msgpack.MarshalCompressed(table.keyToC,
+		Person{Name: "Jason", Age: 18})
+	panicNotNil(err)
+	jason20, err := msgpack.MarshalCompressed(table.keyToC,
+		Person{Name: "Jason", Age: 20})
+	panicNotNil(err)
+
+	_, err = db.writeJournal([]journalEntry{{
+		Table: "read_only_testing",
+		Key:   "jason",
+		Old:   jason18,
+		New:   jason20,
+	}})
+	panicNotNil(err)
+
+	db.Close()
+	time.Sleep(time.Millisecond * 10)
+
+	db, err = OpenReadOnly(dir + "/data")
+	panicNotNil(err)
+
+	table = db.Table("read_only_testing")
+
+	var person Person
+	_, err = table.Get("jason", &person)
+	panicNotNil(err)
+	if person.Age != 18 {
+		t.Fatal("age should be 18, but is", person.Age)
+	}
+
+	var doc Document
+	_, err = table.Get("jason", &doc)
+	panicNotNil(err)
+	if doc.QueryInt("Age") != 18 {
+		t.Fatal("age should be 18, but is", doc.QueryInt("Age"))
+	}
+
+	// The expired document should not have been deleted.
+	if count := table.countBetween(MinValue, MaxValue, true); count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+
+	errs := []error{
+		table.Set("drew", Person{Name: "Drew"}),
+		table.Delete("jason"),
+		table.Update("jason", func(p Person) (Person, error) {
+			return p, nil
+		}),
+		table.SetDefaultTTL(time.Hour),
+		table.NewIndex("Name"),
+		table.Index("Age").Rebuild(),
+		table.Index("Age").Drop(),
+		table.Drop(),
+		db.NewTable("other_testing"),
+		db.Txn(func(tx *Tx) error {
+			return tx.Set("read_only_testing", "drew", Person{})
+		}),
+	}
+
+	for i, err := range errs {
+		if err != ErrReadOnly {
+			t.Fatal("error", i, "should be ErrReadOnly, but is", err)
+		}
+	}
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	_, err = db.Table("read_only_testing").Get("jason", &person)
+	panicNotNil(err)
+	if person.Age != 20 {
+		t.Fatal("age should be 20 after the journal is recovered, but is",
+			person.Age)
+	}
+
+	db.Close()
+
+	// Databases created by older versions don't have a journal, which
+	// shouldn't be created.
+	panicNotNil(os.RemoveAll(dir + "/data/journal"))
+
+	db, err = OpenReadOnly(dir + "/data")
+	panicNotNil(err)
+
+	_, err = db.Table("read_only_testing").Get("jason", &person)
+	panicNotNil(err)
+
+	db.Close()
+
+	if found, _ := exists(dir + "/data/journal"); found {
+		t.Fatal("journal should not have been created, but has")
+	}
+}
@@ -0,0 +1,272 @@
+package cete
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaReport represents the differences between the indexes declared on a
+// type with struct tags, and the indexes of its table.
+type SchemaReport struct {
+	// Created are the names of the indexes which were created.
+	Created []string
+	// Undeclared are the names of the indexes of the table which are not
+	// declared on the type. They are not dropped.
+	Undeclared []string
+	// Mismatched are the names of the indexes which are declared with a
+	// different query or uniqueness than the index in the table. They are
+	// not modified, use Drop and EnsureTable to recreate them.
+	Mismatched []string
+}
+
+type declaredIndex struct {
+	name   string
+	unique bool
+	fields []declaredField
+}
+
+type declaredField struct {
+	query string
+	order int
+	pos   int
+}
+
+func (d declaredIndex) query() string {
+	sort.SliceStable(d.fields, func(a, b int) bool {
+		if d.fields[a].order != d.fields[b].order {
+			return d.fields[a].order < d.fields[b].order
+		}
+		return d.fields[a].pos < d.fields[b].pos
+	})
+
+	queries := make([]string, len(d.fields))
+	for i, field := range d.fields {
+		queries[i] = field.query
+	}
+
+	return strings.Join(queries, ",")
+}
+
+// EnsureTable creates the table if it doesn't exist, and creates any
+// indexes declared on doc's struct type with "cete" struct tags which the
+// table doesn't have. doc must be a struct or a pointer to a struct.
+//
+// Indexes are declared as options of the "cete" tag, after the field's name
+// which may be left empty. A field is indexed with `cete:",index"`, or
+// `cete:",unique"` for a unique index, which are named after the field.
+// Fields which are slices have each of their elements indexed, like a query
+// of "Field.*". Compound indexes are declared by naming the index, and
+// optionally giving the position of each field in it, such as
+// `cete:",index=city_age,order=0"` on a City field and
+// `cete:"age,index=city_age,order=1"` on an Age field which is encoded as
+// "age". A field can be in more than one index, such as
+// `cete:",index,index=city_age,order=0"`. Declaring a named index with
+// "unique=name" on any of its fields makes the index unique.
+//
+// The returned report lists the indexes that were created, and any which
+// differ from their declarations.
+func (d *DB) EnsureTable(name string, doc interface{}) (SchemaReport, error) {
+	var report SchemaReport
+
+	declared, err := declaredIndexes(reflect.TypeOf(doc))
+	if err != nil {
+		return report, err
+	}
+
+	if d.Table(name) == nil {
+		if err = d.NewTable(name); err != nil && err != ErrAlreadyExists {
+			return report, err
+		}
+	}
+
+	t := d.Table(name)
+
+	for _, index := range declared {
+		query := index.query()
+
+		existing := t.Index(index.name)
+		if existing != nil {
+			if existing.query != query || existing.unique != index.unique {
+				report.Mismatched = append(report.Mismatched, index.name)
+			}
+			continue
+		}
+
+		err = t.NewIndex(index.name, IndexOptions{
+			Unique: index.unique,
+			Query:  query,
+		})
+		if err != nil {
+			return report, err
+		}
+
+		report.Created = append(report.Created, index.name)
+	}
+
+	for _, indexName := range t.Indexes() {
+		found := false
+		for _, index := range declared {
+			if index.name == indexName {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			report.Undeclared = append(report.Undeclared, indexName)
+		}
+	}
+
+	sort.Strings(report.Undeclared)
+
+	return report, nil
+}
+
+// declaredIndexes returns the indexes declared on the struct type with
+// struct tags, sorted by name.
+func declaredIndexes(typ reflect.Type) ([]declaredIndex, error) {
+	if typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, errors.New("cete: doc must be a struct or a pointer " +
+			"to a struct")
+	}
+
+	indexes := make(map[string]*declaredIndex)
+	pos := 0
+	if err := declareFields(typ, "", indexes, &pos); err != nil {
+		return nil, err
+	}
+
+	var results []declaredIndex
+	for _, index := range indexes {
+		results = append(results, *index)
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].name < results[b].name
+	})
+
+	return results, nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func declareFields(typ reflect.Type, prefix string,
+	indexes map[string]*declaredIndex, pos *int) error {
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		name, options := field.Tag.Get("cete"), ""
+		if comma := strings.IndexByte(name, ','); comma >= 0 {
+			name, options = name[:comma], name[comma+1:]
+		}
+
+		if name == "-" {
+			continue
+		}
+
+		inline := field.Anonymous && name == ""
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+			nestedPrefix := prefix + name + "."
+			if inline {
+				nestedPrefix = prefix
+			}
+
+			if err := declareFields(fieldType, nestedPrefix, indexes,
+				pos); err != nil {
+				return err
+			}
+		}
+
+		path := prefix + name
+		query := path
+		if (fieldType.Kind() == reflect.Slice &&
+			fieldType.Elem().Kind() != reflect.Uint8) ||
+			fieldType.Kind() == reflect.Array {
+			query += ".*"
+		}
+
+		err := declareIndexes(options, path, query, indexes, *pos)
+		if err != nil {
+			return errors.New("cete: invalid tag on field " +
+				field.Name + ": " + err.Error())
+		}
+
+		*pos++
+	}
+
+	return nil
+}
+
+// declareIndexes adds the field to the indexes declared in the options of
+// its struct tag. Each "index" or "unique" option declares an index, and an
+// "order" option sets the position of the field in the index declared
+// before it.
+func declareIndexes(options, path, query string,
+	indexes map[string]*declaredIndex, pos int) error {
+	var last *declaredField
+
+	for _, option := range strings.Split(options, ",") {
+		key, value := option, ""
+		if eq := strings.IndexByte(option, '='); eq >= 0 {
+			key, value = option[:eq], option[eq+1:]
+		}
+
+		switch key {
+		case "index", "unique":
+			indexName := value
+			if indexName == "" {
+				indexName = path
+			}
+
+			index := indexes[indexName]
+			if index == nil {
+				index = &declaredIndex{name: indexName}
+				indexes[indexName] = index
+			}
+
+			index.unique = index.unique || key == "unique"
+			index.fields = append(index.fields, declaredField{
+				query: query,
+				pos:   pos,
+			})
+			last = &index.fields[len(index.fields)-1]
+		case "order":
+			if last == nil {
+				return errors.New("order must follow an index or unique " +
+					"option")
+			}
+
+			order, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.New("order must be an integer")
+			}
+
+			last.order = order
+		case "", "omitempty", "inline", "noinline", "asArray":
+		default:
+			return errors.New("unknown option \"" + key + "\"")
+		}
+	}
+
+	return nil
+}
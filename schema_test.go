@@ -0,0 +1,131 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type schemaAddress struct {
+	City string `cete:",index,index=city_age,order=0"`
+}
+
+type schemaPerson struct {
+	Email   string `cete:"email,unique"`
+	Name    string
+	Age     int      `cete:",index=city_age,order=1"`
+	Tags    []string `cete:",index,omitempty"`
+	Address schemaAddress
+	secret  string
+}
+
+func TestEnsureTable(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	report, err := db.EnsureTable("schema_testing", &schemaPerson{})
+	panicNotNil(err)
+
+	expected := []string{"Address.City", "Tags", "city_age", "email"}
+	if !reflect.DeepEqual(report.Created, expected) {
+		t.Fatal("created indexes should be", expected, "but are",
+			report.Created)
+	}
+
+	if len(report.Undeclared) != 0 || len(report.Mismatched) != 0 {
+		t.Fatalf("report should only have created indexes, but is %+v", report)
+	}
+
+	table := db.Table("schema_testing")
+	if table.Index("city_age").query != "Address.City,Age" {
+		t.Fatal("city_age query should be Address.City,Age, but is",
+			table.Index("city_age").query)
+	}
+
+	if !table.Index("email").unique || table.Index("city_age").unique {
+		t.Fatal("only the email index should be unique")
+	}
+
+	panicNotNil(table.Set("jason", schemaPerson{
+		Email:   "jason@example.com",
+		Age:     19,
+		Tags:    []string{"admin", "staff"},
+		Address: schemaAddress{City: "Sydney"},
+	}))
+	panicNotNil(table.Set("ben", schemaPerson{
+		Email:   "ben@example.com",
+		Age:     20,
+		Tags:    []string{"staff"},
+		Address: schemaAddress{City: "Sydney"},
+	}))
+
+	err = table.Set("drew", schemaPerson{Email: "jason@example.com"})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	key, _, err := table.Index("city_age").One([]interface{}{"Sydney", 20}, nil)
+	panicNotNil(err)
+	if key != "ben" {
+		t.Fatal("key should be ben, but is", key)
+	}
+
+	count := table.Index("Tags").CountBetween("staff", "staff")
+	if count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+
+	report, err = db.EnsureTable("schema_testing", schemaPerson{})
+	panicNotNil(err)
+	if len(report.Created) != 0 || len(report.Undeclared) != 0 ||
+		len(report.Mismatched) != 0 {
+		t.Fatalf("report should be empty, but is %+v", report)
+	}
+
+	panicNotNil(table.NewIndex("Name"))
+	panicNotNil(table.Index("Tags").Drop())
+	panicNotNil(table.NewIndex("Tags"))
+
+	report, err = db.EnsureTable("schema_testing", schemaPerson{})
+	panicNotNil(err)
+	if !reflect.DeepEqual(report.Undeclared, []string{"Name"}) {
+		t.Fatal("undeclared indexes should be [Name], but are",
+			report.Undeclared)
+	}
+
+	if !reflect.DeepEqual(report.Mismatched, []string{"Tags"}) {
+		t.Fatal("mismatched indexes should be [Tags], but are",
+			report.Mismatched)
+	}
+
+	_, err = db.EnsureTable("schema_testing", "not a struct")
+	if err == nil {
+		t.Fatal("error should not be nil for a non-struct document")
+	}
+
+	type badTag struct {
+		Name string `cete:",indx"`
+	}
+
+	_, err = db.EnsureTable("schema_testing", badTag{})
+	if err == nil {
+		t.Fatal("error should not be nil for an invalid tag")
+	}
+}
@@ -0,0 +1,143 @@
+package cete
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSeq(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("seq_testing"))
+	table := db.Table("seq_testing")
+	panicNotNil(table.NewIndex("Age"))
+
+	for i := 0; i < 200; i++ {
+		panicNotNil(table.Set(fmt.Sprintf("person%03d", i), Person{
+			Name: fmt.Sprintf("Person %d", i),
+			Age:  i % 4,
+		}))
+	}
+
+	r := table.All()
+	i := 0
+	for key, doc := range r.Seq2() {
+		if key != fmt.Sprintf("person%03d", i) {
+			t.Fatal("key should be person", i, "but is", key)
+		}
+
+		if doc.QueryString("Name") != fmt.Sprintf("Person %d", i) {
+			t.Fatal("name should be Person", i, "but is",
+				doc.QueryString("Name"))
+		}
+		i++
+	}
+
+	if i != 200 {
+		t.Fatal("there should be 200 documents, but there were", i)
+	}
+
+	if r.Error() != ErrEndOfRange {
+		t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+	}
+
+	if r.Next() {
+		t.Fatal("next should be false, but is true")
+	}
+
+	// Break out of an index range early.
+	r = table.Index("Age").Between(2, 2, true)
+	i = 0
+	for doc := range r.Seq() {
+		if doc.QueryInt("Age") != 2 {
+			t.Fatal("age should be 2, but is", doc.QueryInt("Age"))
+		}
+
+		i++
+		if i == 10 {
+			break
+		}
+	}
+
+	if r.Error() != ErrEndOfRange {
+		t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+	}
+
+	if r.Next() {
+		t.Fatal("next should be false, but is true")
+	}
+
+	waitForClose(t, r)
+
+	// Ranges which have already been read from are iterated through their
+	// buffer.
+	r = table.All(true)
+	if !r.Next() || r.Key() != "person199" {
+		t.Fatal("first key should be person199, but is", r.Key())
+	}
+
+	for key := range r.Seq2() {
+		if key != "person198" {
+			t.Fatal("key should be person198, but is", key)
+		}
+		break
+	}
+
+	if r.Error() != ErrEndOfRange {
+		t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r = table.BetweenCtx(ctx, MinValue, MaxValue)
+	i = 0
+	for range r.Seq2() {
+		i++
+		if i == 5 {
+			cancel()
+		}
+	}
+
+	if i != 5 || r.Error() != context.Canceled {
+		t.Fatal("error should be context.Canceled after 5 documents, "+
+			"but is", r.Error(), "after", i)
+	}
+
+	panicNotNil(table.Set("bad", "not a person"))
+
+	people := NewTypedTable[Person](table)
+	tr := people.Between(MinValue, "person010")
+	i = 0
+	for key, person := range tr.Seq2() {
+		if person.Name != fmt.Sprintf("Person %d", i) {
+			t.Fatal("name should be Person", i, "but is", person.Name,
+				"for", key)
+		}
+		i++
+	}
+
+	if i != 0 || tr.Error() == nil || tr.Error() == ErrEndOfRange {
+		t.Fatal("error should be a decode error, but is", tr.Error())
+	}
+}
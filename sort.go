@@ -0,0 +1,348 @@
+package cete
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/1lann/msgpack"
+)
+
+const defaultSortMemoryLimit = 64 << 20
+
+// sortEntryOverhead is the approximate number of bytes used by a sortEntry
+// in addition to its key, data and sort key.
+const sortEntryOverhead = 64
+
+// SortOptions represents the options of SortBy and SortByFunc.
+type SortOptions struct {
+	// MemoryLimit is the approximate number of bytes of documents which are
+	// sorted in memory. Once the limit is reached, the sorted documents are
+	// written to a temporary file, and the files are merged together after
+	// the whole range has been read. The default is 64 MiB.
+	MemoryLimit int64
+
+	// TempDir is the directory that temporary files are written to. The
+	// default is os.TempDir().
+	TempDir string
+}
+
+// SortBy sorts the range by the value of the query, in the same way as an
+// index on the query would sort it, in descending order if desc is true.
+// Documents with multiple values, such as with "Likes.*", are sorted by
+// their lowest value, or highest value in descending order. Documents
+// without a value are returned last. The sort is stable.
+//
+// The whole range is read before the first document is returned, and ranges
+// which don't fit within the MemoryLimit of the SortOptions are sorted using
+// temporary files.
+func (r *Range) SortBy(query string, desc bool, opts ...SortOptions) *Range {
+	sortKey := func(doc Document) ([]byte, bool) {
+		var result []byte
+		found := false
+
+		for _, value := range doc.QueryAll(query) {
+			b, err := encodeValue(value, lowerString, currentEncoding)
+			if err != nil {
+				continue
+			}
+
+			if !found || (!desc && bytes.Compare(b, result) < 0) ||
+				(desc && bytes.Compare(b, result) > 0) {
+				result = b
+				found = true
+			}
+		}
+
+		return result, found
+	}
+
+	return r.sort(sortKey, func(a, b *sortEntry) int {
+		if !a.HasKey || !b.HasKey {
+			if a.HasKey == b.HasKey {
+				return 0
+			} else if a.HasKey {
+				return -1
+			}
+			return 1
+		}
+
+		if desc {
+			return bytes.Compare(b.SortKey, a.SortKey)
+		}
+		return bytes.Compare(a.SortKey, b.SortKey)
+	}, opts)
+}
+
+// SortByFunc sorts the range with the compare function, which returns a
+// negative number if a should be before b, a positive number if a should be
+// after b, and 0 if their order should be kept. See SortBy for details.
+func (r *Range) SortByFunc(compare func(a, b Document) int,
+	opts ...SortOptions) *Range {
+	return r.sort(nil, func(a, b *sortEntry) int {
+		return compare(Document{data: a.Data, table: r.table},
+			Document{data: b.Data, table: r.table})
+	}, opts)
+}
+
+// sortEntry is a document being sorted. Its fields are exported so it can
+// be written to temporary files.
+type sortEntry struct {
+	Key     string
+	Data    []byte
+	Counter uint64
+	SortKey []byte
+	HasKey  bool
+}
+
+// sortRun is a sorted run of entries, which is either in memory, or in a
+// temporary file.
+type sortRun struct {
+	index   int
+	entry   *sortEntry
+	entries []*sortEntry
+	file    *os.File
+	dec     *msgpack.Decoder
+}
+
+// next reads the next entry of the run, and sets entry to nil at the end of
+// the run.
+func (s *sortRun) next() error {
+	if s.file == nil {
+		s.entry = nil
+		if len(s.entries) > 0 {
+			s.entry = s.entries[0]
+			s.entries = s.entries[1:]
+		}
+		return nil
+	}
+
+	entry := new(sortEntry)
+	if err := s.dec.Decode(entry); err == io.EOF {
+		s.entry = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s.entry = entry
+	return nil
+}
+
+// sorter sorts a range with an external merge sort.
+type sorter struct {
+	source  *Range
+	sortKey func(doc Document) ([]byte, bool)
+	compare func(a, b *sortEntry) int
+	options SortOptions
+
+	dir     string
+	runs    []*sortRun
+	heap    sortHeap
+	cleanup *sync.Once
+}
+
+type sortHeap struct {
+	runs    []*sortRun
+	compare func(a, b *sortEntry) int
+}
+
+func (h *sortHeap) Len() int {
+	return len(h.runs)
+}
+
+func (h *sortHeap) Less(a, b int) bool {
+	c := h.compare(h.runs[a].entry, h.runs[b].entry)
+	if c != 0 {
+		return c < 0
+	}
+
+	// Keep the sort stable, as earlier runs were read first.
+	return h.runs[a].index < h.runs[b].index
+}
+
+func (h *sortHeap) Swap(a, b int) {
+	h.runs[a], h.runs[b] = h.runs[b], h.runs[a]
+}
+
+func (h *sortHeap) Push(x interface{}) {
+	h.runs = append(h.runs, x.(*sortRun))
+}
+
+func (h *sortHeap) Pop() interface{} {
+	run := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return run
+}
+
+func (r *Range) sort(sortKey func(doc Document) ([]byte, bool),
+	compare func(a, b *sortEntry) int, opts []SortOptions) *Range {
+	s := &sorter{
+		source:  r,
+		sortKey: sortKey,
+		compare: compare,
+		cleanup: new(sync.Once),
+	}
+
+	if len(opts) > 0 {
+		s.options = opts[0]
+	}
+
+	if s.options.MemoryLimit <= 0 {
+		s.options.MemoryLimit = defaultSortMemoryLimit
+	}
+
+	started := false
+
+	return newRangeCtx(r.ctx, func() (string, []byte, uint64, error) {
+		if !started {
+			started = true
+			if err := s.read(); err != nil {
+				return "", nil, 0, err
+			}
+		}
+
+		if s.heap.Len() == 0 {
+			s.close()
+			return "", nil, 0, ErrEndOfRange
+		}
+
+		run := s.heap.runs[0]
+		entry := run.entry
+		if err := run.next(); err != nil {
+			return "", nil, 0, err
+		}
+
+		if run.entry == nil {
+			heap.Pop(&s.heap)
+		} else {
+			heap.Fix(&s.heap, 0)
+		}
+
+		return entry.Key, entry.Data, entry.Counter, nil
+	}, func() {
+		r.Close()
+		s.close()
+	}, r.table)
+}
+
+// read reads the whole source range into sorted runs, and prepares them to
+// be merged.
+func (s *sorter) read() error {
+	var entries []*sortEntry
+	var size int64
+
+	for s.source.Next() {
+		doc := s.source.Document()
+		entry := &sortEntry{
+			Key:     s.source.Key(),
+			Data:    doc.data,
+			Counter: s.source.Counter(),
+		}
+
+		if s.sortKey != nil {
+			entry.SortKey, entry.HasKey = s.sortKey(doc)
+		}
+
+		entries = append(entries, entry)
+		size += int64(len(entry.Key)+len(entry.Data)+len(entry.SortKey)) +
+			sortEntryOverhead
+
+		if size >= s.options.MemoryLimit {
+			if err := s.spill(entries); err != nil {
+				return err
+			}
+
+			entries = nil
+			size = 0
+		}
+	}
+
+	if s.source.Error() != ErrEndOfRange {
+		return s.source.Error()
+	}
+
+	s.sortEntries(entries)
+	s.runs = append(s.runs, &sortRun{index: len(s.runs), entries: entries})
+
+	s.heap.compare = s.compare
+	for _, run := range s.runs {
+		if err := run.next(); err != nil {
+			return err
+		}
+
+		if run.entry != nil {
+			s.heap.runs = append(s.heap.runs, run)
+		}
+	}
+
+	heap.Init(&s.heap)
+	return nil
+}
+
+func (s *sorter) sortEntries(entries []*sortEntry) {
+	sort.SliceStable(entries, func(a, b int) bool {
+		return s.compare(entries[a], entries[b]) < 0
+	})
+}
+
+// spill sorts the entries and writes them to a temporary file.
+func (s *sorter) spill(entries []*sortEntry) error {
+	s.sortEntries(entries)
+
+	if s.dir == "" {
+		dir, err := ioutil.TempDir(s.options.TempDir, "cete_sort_")
+		if err != nil {
+			return err
+		}
+		s.dir = dir
+	}
+
+	file, err := os.Create(s.dir + "/" + strconv.Itoa(len(s.runs)))
+	if err != nil {
+		return err
+	}
+
+	run := &sortRun{index: len(s.runs), file: file}
+	s.runs = append(s.runs, run)
+
+	wr := bufio.NewWriter(file)
+	enc := msgpack.NewEncoder(wr)
+	for _, entry := range entries {
+		if err = enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if err = wr.Flush(); err != nil {
+		return err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	run.dec = msgpack.NewDecoder(bufio.NewReader(file))
+	return nil
+}
+
+// close closes and removes the temporary files of the sorter.
+func (s *sorter) close() {
+	s.cleanup.Do(func() {
+		for _, run := range s.runs {
+			if run.file != nil {
+				run.file.Close()
+			}
+		}
+
+		if s.dir != "" {
+			os.RemoveAll(s.dir)
+		}
+	})
+}
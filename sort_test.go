@@ -0,0 +1,126 @@
+package cete
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestSortBy(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("sort_testing"))
+	table := db.Table("sort_testing")
+
+	const numPeople = 300
+	for i := 0; i < numPeople; i++ {
+		panicNotNil(table.Set(paddedItoa(i), Person{
+			Name: "Person " + strconv.Itoa(i),
+			Age:  rand.Intn(50) - 10,
+		}))
+	}
+
+	panicNotNil(table.Set("unknown", map[string]interface{}{"Name": "?"}))
+
+	tempDir := dir + "/temp"
+	panicNotNil(os.Mkdir(tempDir, 0744))
+	options := SortOptions{MemoryLimit: 2048, TempDir: tempDir}
+
+	expectSorted := func(r *Range, desc bool) {
+		t.Helper()
+
+		n := 0
+		last := 0
+		for r.Next() {
+			if n == 0 {
+				files, err := ioutil.ReadDir(tempDir)
+				panicNotNil(err)
+				if len(files) != 1 {
+					t.Fatal("sort should have spilled to disk")
+				}
+			}
+
+			if n == numPeople {
+				if r.Key() != "unknown" {
+					t.Fatal("document without a value should be last, but "+
+						"is", r.Key())
+				}
+				n++
+				continue
+			}
+
+			var person Person
+			panicNotNil(r.Decode(&person))
+			if n > 0 && ((!desc && person.Age < last) ||
+				(desc && person.Age > last)) {
+				t.Fatal("range should be sorted, but", person.Age,
+					"is after", last)
+			}
+
+			last = person.Age
+			n++
+		}
+
+		if r.Error() != ErrEndOfRange {
+			t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+		}
+
+		if n != numPeople+1 {
+			t.Fatal("range should have", numPeople+1, "documents, but has", n)
+		}
+
+		files, err := ioutil.ReadDir(tempDir)
+		panicNotNil(err)
+		if len(files) != 0 {
+			t.Fatal("temporary files should be removed, but there are",
+				len(files))
+		}
+	}
+
+	expectSorted(table.All().SortBy("Age", false, options), false)
+	expectSorted(table.All().SortBy("Age", true, options), true)
+
+	// Ties keep the order of the range.
+	var keys []string
+	r := table.All().SortByFunc(func(a, b Document) int {
+		return len(a.QueryString("Name")) - len(b.QueryString("Name"))
+	}, options)
+	for r.Next() {
+		keys = append(keys, r.Key())
+	}
+
+	if len(keys) != numPeople+1 || keys[0] != "unknown" ||
+		keys[1] != paddedItoa(0) || keys[11] != paddedItoa(10) ||
+		keys[numPeople] != paddedItoa(numPeople-1) {
+		t.Fatal("range should be stably sorted by name length, but is", keys)
+	}
+
+	r = table.All().SortBy("Age", false, options)
+	r.Next()
+	r.Close()
+
+	files, err := ioutil.ReadDir(tempDir)
+	panicNotNil(err)
+	if len(files) != 0 {
+		t.Fatal("temporary files should be removed when the range is closed")
+	}
+}
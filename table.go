@@ -2,12 +2,13 @@ package cete
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"log"
 	"os"
 	"reflect"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 
 	"github.com/1lann/badger"
 	"github.com/1lann/msgpack"
@@ -19,6 +20,10 @@ import (
 // the keys in your document are very dynamic, as the key compression map
 // is stored in memory.
 func (d *DB) NewTable(name string, keyCompression ...bool) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
 	if name == "" || len(name) > 125 {
 		return ErrBadIdentifier
 	}
@@ -51,9 +56,13 @@ func (d *DB) NewTable(name string, keyCompression ...bool) error {
 	}
 
 	tb := &Table{
-		indexes: make(map[Name]*Index),
-		data:    kv,
-		db:      d,
+		indexes:    make(map[Name]*Index),
+		data:       kv,
+		db:         d,
+		uniqueLock: new(sync.Mutex),
+		watchLock:  new(sync.Mutex),
+		watchers:   make(map[*watcher]bool),
+		keyLocks:   newKeyLocks(),
 	}
 
 	if useKeyCompression {
@@ -65,11 +74,20 @@ func (d *DB) NewTable(name string, keyCompression ...bool) error {
 
 	d.tables[Name(name)] = tb
 
+	go tb.runReaper()
+
 	return nil
 }
 
 // Drop drops the table from the database.
 func (t *Table) Drop() error {
+	if t.db.readOnly {
+		return ErrReadOnly
+	}
+
+	t.db.commitLock.Lock()
+	defer t.db.commitLock.Unlock()
+
 	t.db.configMutex.Lock()
 	defer t.db.configMutex.Unlock()
 
@@ -97,8 +115,12 @@ func (t *Table) Drop() error {
 		return err
 	}
 
+	t.stopWatchers()
+	atomic.StoreInt32(&t.dropped, 1)
+
 	// Close the index and table stores
 	for _, index := range t.indexes {
+		index.stopMigration()
 		index.index.Close()
 	}
 	t.data.Close()
@@ -110,6 +132,7 @@ func (t *Table) Drop() error {
 
 // Get retrieves a value from a table with its primary key. dst must either be
 // a pointer or nil if you only want to get the counter or check for existence.
+// dst can also be a *Document, to get the document without decoding it.
 func (t *Table) Get(key string, dst interface{}) (uint64, error) {
 	var item badger.KVItem
 	err := t.data.Get([]byte(key), &item)
@@ -118,7 +141,7 @@ func (t *Table) Get(key string, dst interface{}) (uint64, error) {
 	}
 
 	itemValue := getItemValue(&item)
-	if itemValue == nil {
+	if itemValue == nil || itemExpired(&item) {
 		return 0, ErrNotFound
 	}
 
@@ -126,6 +149,13 @@ func (t *Table) Get(key string, dst interface{}) (uint64, error) {
 		return item.Counter(), nil
 	}
 
+	if doc, ok := dst.(*Document); ok {
+		data := make([]byte, len(itemValue))
+		copy(data, itemValue)
+		*doc = Document{data: data, table: t}
+		return item.Counter(), nil
+	}
+
 	if t.keyToCompressed != nil {
 		return item.Counter(), msgpack.UnmarshalCompressed(t.cToKey,
 			itemValue, dst)
@@ -137,15 +167,63 @@ func (t *Table) Get(key string, dst interface{}) (uint64, error) {
 // Set sets a value in the table. An optional counter value can be provided
 // to only set the value if the counter value is the same. A counter value
 // of 0 is valid and represents a key that doesn't exist.
+//
+// ErrUniqueViolation will be returned if the value would violate a unique
+// index of the table.
+//
+// The write is recorded in the database's journal before the document is
+// updated, so if the process stops before the indexes of the table are
+// updated, they will be repaired the next time the database is opened.
+// If the document is written but its indexes fail to update, an IndexError
+// is returned, and the indexes are also repaired the next time the
+// database is opened.
+//
+// Values of the document which can't be indexed, such as maps, are left out
+// of their index, and an IndexError wrapping ErrUnsupportedValue is returned
+// after the document has been written.
+//
+// If the table has a default TTL, the document will expire after it.
 func (t *Table) Set(key string, value interface{}, counter ...uint64) error {
+	return t.set(key, value, t.defaultExpiry(), counter...)
+}
+
+func (t *Table) set(key string, value interface{}, expires int64,
+	counter ...uint64) error {
+	if t.db.readOnly {
+		return ErrReadOnly
+	}
+
+	t.db.commitLock.RLock()
+	defer t.db.commitLock.RUnlock()
+
+	if err := t.checkFuncs(); err != nil {
+		return err
+	}
+
+	keyLock := t.keyLock(key)
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
+	unique := t.hasUniqueIndex()
+	if unique {
+		t.uniqueLock.Lock()
+		defer t.uniqueLock.Unlock()
+	}
+
 	var item badger.KVItem
 	err := t.data.Get([]byte(key), &item)
 	if err != nil {
 		return err
 	}
 
+	// Expired documents are treated as if they don't exist.
+	current := item.Counter()
+	if itemExpired(&item) {
+		current = 0
+	}
+
 	if len(counter) > 0 {
-		if item.Counter() != counter[0] {
+		if current != counter[0] {
 			return ErrCounterChanged
 		}
 	}
@@ -160,17 +238,47 @@ func (t *Table) Set(key string, value interface{}, counter ...uint64) error {
 		return err
 	}
 
+	old := getItemValue(&item)
+
+	if unique {
+		err = t.db.checkUnique([]journalEntry{{
+			Table: t.name(),
+			Key:   key,
+			Old:   old,
+			New:   data,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	seq, err := t.db.writeJournal([]journalEntry{{
+		Table:   t.name(),
+		Key:     key,
+		Old:     old,
+		New:     data,
+		Expires: expires,
+		Intent:  true,
+	}})
+	if err != nil {
+		return err
+	}
+
+	itemValue, userMeta := withExpiry(data, expires)
+
 	if len(counter) > 0 {
-		if counter[0] == 0 {
-			err = t.data.SetIfAbsent([]byte(key), data, 0)
+		if old == nil {
+			err = t.data.SetIfAbsent([]byte(key), itemValue, userMeta)
 		} else {
-			err = t.data.CompareAndSet([]byte(key), data, counter[0])
+			err = compareAndSet(t.data, []byte(key), itemValue, userMeta,
+				item.Counter())
 		}
 	} else {
-		err = t.data.Set([]byte(key), data, 0)
+		err = t.data.Set([]byte(key), itemValue, userMeta)
 	}
 
 	if err == badger.ErrCasMismatch || err == badger.ErrKeyExists {
+		t.db.journal.Delete(seq)
 		return ErrCounterChanged
 	}
 
@@ -178,23 +286,43 @@ func (t *Table) Set(key string, value interface{}, counter ...uint64) error {
 		return err
 	}
 
-	t.updateIndex(key, getItemValue(&item), data)
+	skipped, indexErr := t.updateIndex(key, old, data)
+	t.notify(key, old, data, current, t.writtenCounter(key))
 
-	return nil
+	if indexErr != nil {
+		// Leave the entry in the journal, so the index is repaired
+		// the next time the database is opened.
+		return indexErr
+	}
+
+	if err = t.db.journal.Delete(seq); err != nil {
+		return err
+	}
+
+	return skipped
 }
 
+// diffEntry represents an index value of a document. If migration is true,
+// the value is of the store that the index is being migrated into.
 type diffEntry struct {
 	indexName string
 	indexKey  []byte
+	migration bool
 }
 
-func (t *Table) diffIndexes(old, new []byte) ([]diffEntry, []diffEntry) {
-	var removals []diffEntry
-	var additions []diffEntry
-
+// diffIndexes returns the index values to add and remove to change the
+// indexes of the document from old to new. If any of the values of new can't
+// be indexed, they're left out, and an IndexError wrapping
+// ErrUnsupportedValue is returned as skipped.
+func (t *Table) diffIndexes(key string, old, new []byte) (additions,
+	removals []diffEntry, skipped error) {
 	for indexName, index := range t.indexes {
-		oldRawValues, _ := index.indexQuery(old, string(indexName))
-		newRawValues, _ := index.indexQuery(new, string(indexName))
+		if index.build != nil && index.skipsKey(key) {
+			continue
+		}
+
+		oldRawValues, _ := index.values(old)
+		newRawValues, _ := index.values(new)
 
 		if oldRawValues == nil || len(old) == 0 {
 			oldRawValues = []interface{}{}
@@ -204,15 +332,11 @@ func (t *Table) diffIndexes(old, new []byte) ([]diffEntry, []diffEntry) {
 			newRawValues = []interface{}{}
 		}
 
-		oldValues := make([][]byte, len(oldRawValues))
-		newValues := make([][]byte, len(newRawValues))
+		oldValues := index.indexKeys(oldRawValues)
+		newValues := index.indexKeys(newRawValues)
 
-		for i, oldRawValue := range oldRawValues {
-			oldValues[i] = valueToBytes(oldRawValue)
-		}
-
-		for i, newRawValue := range newRawValues {
-			newValues[i] = valueToBytes(newRawValue)
+		if len(newValues) < len(newRawValues) {
+			skipped = index.indexError(key, ErrUnsupportedValue)
 		}
 
 		additions = append(additions, getOneWayDiffs(string(indexName),
@@ -220,9 +344,22 @@ func (t *Table) diffIndexes(old, new []byte) ([]diffEntry, []diffEntry) {
 
 		removals = append(removals, getOneWayDiffs(string(indexName),
 			oldValues, newValues)...)
+
+		// Documents which have already been migrated must also be updated
+		// in the store that the index is being migrated into.
+		if m := index.migration; m != nil && !m.target.skipsKey(key) {
+			oldValues = m.target.indexKeys(oldRawValues)
+			newValues = m.target.indexKeys(newRawValues)
+
+			additions = append(additions, migrationDiffs(getOneWayDiffs(
+				string(indexName), newValues, oldValues))...)
+
+			removals = append(removals, migrationDiffs(getOneWayDiffs(
+				string(indexName), oldValues, newValues))...)
+		}
 	}
 
-	return additions, removals
+	return additions, removals, skipped
 }
 
 func getOneWayDiffs(indexName string, a, b [][]byte) []diffEntry {
@@ -238,32 +375,67 @@ func getOneWayDiffs(indexName string, a, b [][]byte) []diffEntry {
 		}
 
 		if !found {
-			results = append(results, diffEntry{indexName, aa})
+			results = append(results, diffEntry{
+				indexName: indexName,
+				indexKey:  aa,
+			})
 		}
 	}
 
 	return results
 }
 
-func (t *Table) updateIndex(key string, old, new []byte) error {
-	additions, removals := t.diffIndexes(old, new)
+func migrationDiffs(entries []diffEntry) []diffEntry {
+	for i := range entries {
+		entries[i].migration = true
+	}
+
+	return entries
+}
 
+// diffTarget returns the index that the entry is to be applied to.
+func (t *Table) diffTarget(entry diffEntry) *Index {
+	index := t.Index(entry.indexName)
+	if entry.migration {
+		return index.migration.target
+	}
+
+	return index
+}
+
+// updateIndex updates the indexes of the document from old to new. skipped
+// is returned as described by diffIndexes.
+func (t *Table) updateIndex(key string, old, new []byte) (skipped,
+	err error) {
+	additions, removals, skipped := t.diffIndexes(key, old, new)
+
+	lastError := t.removeFromIndexes(key, removals)
+	if err := t.addToIndexes(key, additions); err != nil {
+		lastError = err
+	}
+
+	return skipped, lastError
+}
+
+func (t *Table) removeFromIndexes(key string, removals []diffEntry) error {
 	var lastError error
 
 	for _, removal := range removals {
-		err := t.Index(removal.indexName).deleteFromIndex(removal.indexKey, key)
+		err := t.diffTarget(removal).deleteFromIndex(removal.indexKey, key)
 		if err != nil {
-			log.Println("cete: error while updating index \""+
-				removal.indexName+"\", index likely corrupt:", err)
 			lastError = err
 		}
 	}
 
+	return lastError
+}
+
+func (t *Table) addToIndexes(key string, additions []diffEntry) error {
+	var lastError error
+
 	for _, addition := range additions {
-		err := t.Index(addition.indexName).addToIndex(addition.indexKey, key)
+		err := t.diffTarget(addition).addToIndex(addition.indexKey, key)
 		if err != nil {
-			log.Println("cete: error while updating index \""+
-				addition.indexName+"\", index likely corrupt:", err)
 			lastError = err
 		}
 	}
@@ -271,26 +443,118 @@ func (t *Table) updateIndex(key string, old, new []byte) error {
 	return lastError
 }
 
+func (t *Table) hasUniqueIndex() bool {
+	for _, index := range t.indexes {
+		if index.unique {
+			return true
+		}
+	}
+
+	return false
+}
+
+type uniqueClaim struct {
+	table    string
+	index    string
+	indexKey string
+}
+
+// checkUnique returns ErrUniqueViolation if applying the given writes would
+// result in more than one document having the same value in a unique index.
+func (d *DB) checkUnique(entries []journalEntry) error {
+	claims := make(map[uniqueClaim]string)
+	released := make(map[uniqueClaim]map[string]bool)
+
+	for _, entry := range entries {
+		t := d.tables[Name(entry.Table)]
+		if t == nil || !t.hasUniqueIndex() {
+			continue
+		}
+
+		additions, removals, _ := t.diffIndexes(entry.Key, entry.Old,
+			entry.New)
+
+		for _, removal := range removals {
+			if removal.migration ||
+				!t.Index(removal.indexName).enforcesUnique(removal.indexKey) {
+				continue
+			}
+
+			claim := uniqueClaim{entry.Table, removal.indexName,
+				string(removal.indexKey)}
+			if released[claim] == nil {
+				released[claim] = make(map[string]bool)
+			}
+			released[claim][entry.Key] = true
+		}
+
+		for _, addition := range additions {
+			if addition.migration ||
+				!t.Index(addition.indexName).enforcesUnique(addition.indexKey) {
+				continue
+			}
+
+			claim := uniqueClaim{entry.Table, addition.indexName,
+				string(addition.indexKey)}
+			if other, found := claims[claim]; found && other != entry.Key {
+				return ErrUniqueViolation
+			}
+			claims[claim] = entry.Key
+		}
+	}
+
+	var item badger.KVItem
+
+	for claim, key := range claims {
+		index := d.tables[Name(claim.table)].Index(claim.index)
+		err := index.index.Get([]byte(claim.indexKey), &item)
+		if err != nil {
+			return index.indexError(key, err)
+		}
+
+		itemValue := getItemValue(&item)
+		if itemValue == nil {
+			continue
+		}
+
+		var list []string
+		err = msgpack.Unmarshal(itemValue, &list)
+		if err != nil {
+			return index.indexError(key, err)
+		}
+
+		for _, holder := range list {
+			if holder != key && !released[claim][holder] &&
+				!index.table.expired(holder) {
+				return ErrUniqueViolation
+			}
+		}
+	}
+
+	return nil
+}
+
+// deleteFromIndex removes the key from the index value. An IndexError
+// wrapping ErrCorruptIndex is returned if the index value doesn't contain
+// the key.
 func (i *Index) deleteFromIndex(indexKey []byte, key string) error {
 	var item badger.KVItem
 
 	for {
 		err := i.index.Get(indexKey, &item)
 		if err != nil {
-			return err
+			return i.indexError(key, err)
 		}
 
 		itemValue := getItemValue(&item)
 		if itemValue == nil {
-			log.Println("cete: warning: corrupt index detected:", i.name())
-			return nil
+			return i.indexError(key, ErrCorruptIndex)
 		}
 
 		var list []string
 		err = msgpack.Unmarshal(itemValue, &list)
 		if err != nil {
-			log.Println("cete: warning: corrupt index detected:", i.name())
-			return err
+			return i.indexError(key, err)
 		}
 
 		found := false
@@ -304,40 +568,50 @@ func (i *Index) deleteFromIndex(indexKey []byte, key string) error {
 		}
 
 		if !found {
-			log.Println("cete: warning: corrupt index detected:", i.name())
-			return nil
+			return i.indexError(key, ErrCorruptIndex)
 		}
 
 		if len(list) == 0 {
 			err = i.index.CompareAndDelete(indexKey, item.Counter())
 			if err == badger.ErrCasMismatch {
 				continue
+			} else if err != nil {
+				return i.indexError(key, err)
 			}
 
-			return err
+			return nil
 		}
 
 		data, err := msgpack.Marshal(list)
 		if err != nil {
-			log.Fatal("cete: marshal should never fail: ", err)
+			return i.indexError(key, err)
 		}
 
 		err = i.index.CompareAndSet(indexKey, data, item.Counter())
 		if err == badger.ErrCasMismatch {
 			continue
+		} else if err != nil {
+			return i.indexError(key, err)
 		}
 
-		return err
+		return nil
 	}
 }
 
+// enforcesUnique returns true if no more than one document may have the
+// index value. Documents whose value in a unique index is nil don't
+// conflict with each other, so that optional fields can be unique.
+func (i *Index) enforcesUnique(indexKey []byte) bool {
+	return i.unique && !bytes.Equal(indexKey, nilBytes)
+}
+
 func (i *Index) addToIndex(indexKey []byte, key string) error {
 	var item badger.KVItem
 
 	for {
 		err := i.index.Get(indexKey, &item)
 		if err != nil {
-			return err
+			return i.indexError(key, err)
 		}
 
 		var list []string
@@ -346,8 +620,7 @@ func (i *Index) addToIndex(indexKey []byte, key string) error {
 		if itemValue != nil {
 			err = msgpack.Unmarshal(itemValue, &list)
 			if err != nil {
-				log.Println("cete: warning: corrupt index detected:", i.name())
-				return err
+				return i.indexError(key, err)
 			}
 		}
 
@@ -358,11 +631,21 @@ func (i *Index) addToIndex(indexKey []byte, key string) error {
 			}
 		}
 
+		if i.enforcesUnique(indexKey) {
+			// Expired documents keep their index values until they are
+			// deleted, but shouldn't prevent others from taking them.
+			for _, holder := range list {
+				if !i.table.expired(holder) {
+					return ErrUniqueViolation
+				}
+			}
+		}
+
 		list = append(list, key)
 
 		data, err := msgpack.Marshal(list)
 		if err != nil {
-			log.Fatal("cete: marshal should never fail: ", err)
+			return i.indexError(key, err)
 		}
 
 		if itemValue == nil {
@@ -377,23 +660,75 @@ func (i *Index) addToIndex(indexKey []byte, key string) error {
 			}
 		}
 
-		return err
+		if err != nil {
+			return i.indexError(key, err)
+		}
+
+		return nil
+	}
+}
+
+// indexError wraps an error which occurred while reading or updating the
+// index for the document with the given key in an IndexError.
+func (i *Index) indexError(key string, err error) error {
+	return &IndexError{
+		Table: i.table.name(),
+		Index: i.indexName(),
+		Key:   key,
+		Err:   err,
 	}
 }
 
 func (i *Index) name() string {
+	return i.table.name() + "/" + i.indexName()
+}
+
+func (i *Index) indexName() string {
 	for indexName, index := range i.table.indexes {
 		if index == i {
-			return i.table.name() + "/" + string(indexName)
+			return string(indexName)
 		}
 	}
 
-	return i.table.name() + "/__unknown_index"
+	return "__unknown_index"
+}
+
+// kv returns the index's store. It must be used by readers which do not hold
+// the database's commit lock, as the store is replaced when the index is
+// rebuilt.
+func (i *Index) kv() *badger.KV {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	return i.index
 }
 
 // Delete deletes the key from the table. An optional counter value can be
 // provided to only delete the document if the counter value is the same.
+// Like Set, the delete is recorded in the database's journal first, and an
+// IndexError is returned if the document is deleted but its indexes fail
+// to update.
 func (t *Table) Delete(key string, counter ...uint64) error {
+	t.db.commitLock.RLock()
+	defer t.db.commitLock.RUnlock()
+
+	return t.delete(key, counter...)
+}
+
+// delete deletes the key from the table. The database's commit lock must be
+// held by the caller.
+func (t *Table) delete(key string, counter ...uint64) error {
+	if t.db.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := t.checkFuncs(); err != nil {
+		return err
+	}
+
+	keyLock := t.keyLock(key)
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
 	var item badger.KVItem
 	err := t.data.Get([]byte(key), &item)
 	if err != nil {
@@ -405,17 +740,28 @@ func (t *Table) Delete(key string, counter ...uint64) error {
 		return nil
 	}
 
-	if len(counter) > 0 {
-		if item.Counter() != counter[0] {
-			return ErrCounterChanged
-		}
+	if len(counter) > 0 && item.Counter() != counter[0] {
+		return ErrCounterChanged
+	}
 
+	seq, err := t.db.writeJournal([]journalEntry{{
+		Table:  t.name(),
+		Key:    key,
+		Old:    itemValue,
+		Intent: true,
+	}})
+	if err != nil {
+		return err
+	}
+
+	if len(counter) > 0 {
 		err = t.data.CompareAndDelete([]byte(key), counter[0])
 	} else {
 		err = t.data.Delete([]byte(key))
 	}
 
 	if err == badger.ErrCasMismatch {
+		t.db.journal.Delete(seq)
 		return ErrCounterChanged
 	}
 
@@ -423,9 +769,14 @@ func (t *Table) Delete(key string, counter ...uint64) error {
 		return err
 	}
 
-	t.updateIndex(key, itemValue, nil)
+	_, indexErr := t.updateIndex(key, itemValue, nil)
+	t.notify(key, itemValue, nil, item.Counter(), 0)
 
-	return nil
+	if indexErr != nil {
+		return indexErr
+	}
+
+	return t.db.journal.Delete(seq)
 }
 
 // Index returns the index object of an index of the table. If the index does
@@ -441,7 +792,8 @@ func (t *Table) Index(index string) *Index {
 // whether or not the update should be aborted, and will be returned back from
 // Update.
 //
-// ErrNotFound will be returned if the document does not exist.
+// ErrNotFound will be returned if the document does not exist. The document
+// keeps its expiry time, if it has one.
 //
 // The modifier function will be continuously called until the counter at the
 // beginning of handler matches the counter when the document is updated.
@@ -478,7 +830,14 @@ func (t *Table) Update(key string, handler interface{}) error {
 			return result[1].Interface().(error)
 		}
 
-		err = t.Set(key, result[0].Interface(), counter, 0)
+		expires, err := t.currentExpiry(key, counter)
+		if err == ErrCounterChanged {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = t.set(key, result[0].Interface(), expires, counter)
 		if err == ErrCounterChanged {
 			continue
 		}
@@ -509,6 +868,20 @@ func (t *Table) name() string {
 // bound values.
 func (t *Table) Between(lower interface{}, upper interface{},
 	reverse ...bool) *Range {
+	return t.between(context.Background(), lower, upper, false, reverse...)
+}
+
+// BetweenCtx is like Between, but the range is closed and returns ctx.Err()
+// when the context is done.
+func (t *Table) BetweenCtx(ctx context.Context, lower interface{},
+	upper interface{}, reverse ...bool) *Range {
+	return t.between(ctx, lower, upper, false, reverse...)
+}
+
+// between is like Between, but also returns expired documents which have yet
+// to be deleted if withExpired is true.
+func (t *Table) between(ctx context.Context, lower interface{},
+	upper interface{}, withExpired bool, reverse ...bool) *Range {
 	if lower == MaxValue || upper == MinValue {
 		return newRange(func() (string, []byte, uint64, error) {
 			return "", nil, 0, ErrEndOfRange
@@ -528,7 +901,7 @@ func (t *Table) Between(lower interface{}, upper interface{},
 	_, lowerIsBounds := lower.(Bounds)
 	if (!upperIsString && !upperIsBounds) ||
 		(!lowerIsString && !lowerIsBounds) {
-		log.Println("cete: warning: lower and upper bounds of " +
+		t.db.log("cete: warning: lower and upper bounds of " +
 			"table.Between must be a string or Bounds. An empty range has " +
 			"been returned instead")
 		return newRange(func() (string, []byte, uint64, error) {
@@ -557,7 +930,7 @@ func (t *Table) Between(lower interface{}, upper interface{},
 	var counter uint64
 	var value []byte
 
-	return newRange(func() (string, []byte, uint64, error) {
+	return newRangeCtx(ctx, func() (string, []byte, uint64, error) {
 		for it.Valid() {
 			if !shouldReverse && upper != MaxValue &&
 				bytes.Compare(it.Item().Key(), upperBytes) > 0 {
@@ -567,6 +940,11 @@ func (t *Table) Between(lower interface{}, upper interface{},
 				return "", nil, 0, ErrEndOfRange
 			}
 
+			if !withExpired && itemExpired(it.Item()) {
+				it.Next()
+				continue
+			}
+
 			key = string(it.Item().Key())
 			counter = it.Item().Counter()
 			itemValue := getItemValue(it.Item())
@@ -584,6 +962,10 @@ func (t *Table) Between(lower interface{}, upper interface{},
 // within the given inclusive bounds. Lower and upper must be strings or Bounds.
 // It's an optimized version of Between(lower, upper).Count().
 func (t *Table) CountBetween(lower, upper interface{}) int64 {
+	return t.countBetween(lower, upper, false)
+}
+
+func (t *Table) countBetween(lower, upper interface{}, withExpired bool) int64 {
 	if lower == MaxValue || upper == MinValue {
 		return 0
 	}
@@ -596,7 +978,7 @@ func (t *Table) CountBetween(lower, upper interface{}) int64 {
 	upperString, isString := upper.(string)
 	_, isBounds := upper.(Bounds)
 	if !isString && !isBounds {
-		log.Println("cete: warning: lower and upper bounds of " +
+		t.db.log("cete: warning: lower and upper bounds of " +
 			"table.CountBetween must be a string or Bounds. A count of 0 has " +
 			"been returned instead")
 		return 0
@@ -605,7 +987,7 @@ func (t *Table) CountBetween(lower, upper interface{}) int64 {
 	lowerString, isString := lower.(string)
 	_, isBounds = lower.(Bounds)
 	if !isString && !isBounds {
-		log.Println("cete: warning: lower and upper bounds of " +
+		t.db.log("cete: warning: lower and upper bounds of " +
 			"table.CountBetween must be a string or Bounds. A count of 0 has " +
 			"been returned instead")
 		return 0
@@ -628,7 +1010,9 @@ func (t *Table) CountBetween(lower, upper interface{}) int64 {
 			return count
 		}
 
-		count++
+		if withExpired || !itemExpired(it.Item()) {
+			count++
+		}
 
 		it.Next()
 	}
@@ -729,9 +1113,9 @@ func (t *Table) cToKey(compressed string) string {
 
 	value, found := t.compressedToKey[compressed]
 	if !found {
-		log.Println("cete: warning: failed to decompress non-existent "+
+		t.db.log("cete: warning: failed to decompress non-existent "+
 			"compressed key:", compressed)
-		log.Println(string(debug.Stack()))
+		t.db.log(string(debug.Stack()))
 		return compressed
 	}
 
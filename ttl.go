@@ -0,0 +1,238 @@
+package cete
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/1lann/badger"
+)
+
+// metaExpires is set in the user meta of documents which have an expiry
+// time. The expiry time is stored as the first 8 bytes of the value, in
+// nanoseconds since the Unix epoch.
+const metaExpires byte = 1
+
+// The interval between each sweep of a table for expired documents.
+const reapInterval = time.Second * 10
+
+var errTableClosed = errors.New("cete: table closed")
+
+// SetWithTTL sets a value in the table, which expires after the given
+// duration. Expired documents are not returned by Get, Between or index
+// queries, and are deleted from the table and its indexes in the
+// background. A ttl of 0 or less means the document never expires, even
+// if the table has a default TTL.
+//
+// Like Set, an optional counter value can be provided to only set the value
+// if the counter value is the same.
+func (t *Table) SetWithTTL(key string, value interface{}, ttl time.Duration,
+	counter ...uint64) error {
+	return t.set(key, value, expiresAt(ttl), counter...)
+}
+
+// SetDefaultTTL sets the TTL of documents written to the table with Set or
+// a transaction. A ttl of 0 disables the default TTL. Documents which have
+// already been written are not affected, and keep their expiry time when
+// they're updated with Update.
+func (t *Table) SetDefaultTTL(ttl time.Duration) error {
+	if t.db.readOnly {
+		return ErrReadOnly
+	}
+
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	t.db.configMutex.Lock()
+	defer t.db.configMutex.Unlock()
+
+	tableName := t.name()
+	for i, table := range t.db.config.Tables {
+		if table.TableName == tableName {
+			previous := t.db.config.Tables[i].DefaultTTL
+			t.db.config.Tables[i].DefaultTTL = ttl
+			if err := t.db.writeConfig(); err != nil {
+				t.db.config.Tables[i].DefaultTTL = previous
+				return err
+			}
+
+			atomic.StoreInt64(&t.defaultTTL, int64(ttl))
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// DefaultTTL returns the default TTL of the table, or 0 if it has none.
+func (t *Table) DefaultTTL() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.defaultTTL))
+}
+
+func (t *Table) defaultExpiry() int64 {
+	return expiresAt(t.DefaultTTL())
+}
+
+func expiresAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// withExpiry returns the value and user meta to store a document with. An
+// expires value of 0 means the document never expires.
+func withExpiry(data []byte, expires int64) ([]byte, byte) {
+	if expires == 0 {
+		return data, 0
+	}
+
+	value := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(value, uint64(expires))
+	copy(value[8:], data)
+
+	return value, metaExpires
+}
+
+// setDocument writes the document to the table, which expires at the given
+// time if expires isn't 0.
+func (t *Table) setDocument(key string, data []byte, expires int64) error {
+	value, userMeta := withExpiry(data, expires)
+	return t.data.Set([]byte(key), value, userMeta)
+}
+
+// itemExpiry returns the time the item expires in nanoseconds since the Unix
+// epoch, or 0 if the item never expires.
+func itemExpiry(item *badger.KVItem) int64 {
+	if item == nil || item.UserMeta()&metaExpires == 0 {
+		return 0
+	}
+
+	var expires int64
+	item.Value(func(value []byte) error {
+		if len(value) >= 8 {
+			expires = int64(binary.BigEndian.Uint64(value))
+		}
+		return nil
+	})
+
+	return expires
+}
+
+func itemExpired(item *badger.KVItem) bool {
+	expires := itemExpiry(item)
+	return expires != 0 && expires <= time.Now().UnixNano()
+}
+
+// expired returns true if the document with the given key exists, but has
+// expired.
+func (t *Table) expired(key string) bool {
+	var item badger.KVItem
+	if err := t.data.Get([]byte(key), &item); err != nil {
+		return false
+	}
+
+	return itemExpired(&item)
+}
+
+// currentExpiry returns the expiry time of the document with the key, so
+// that it can be kept by an update. ErrCounterChanged is returned if the
+// document's counter is no longer the given counter.
+func (t *Table) currentExpiry(key string, counter uint64) (int64, error) {
+	var item badger.KVItem
+	if err := t.data.Get([]byte(key), &item); err != nil {
+		return 0, err
+	}
+
+	if item.Counter() != counter {
+		return 0, ErrCounterChanged
+	}
+
+	return itemExpiry(&item), nil
+}
+
+// compareAndSet is like badger's CompareAndSet, but with user meta.
+func compareAndSet(kv *badger.KV, key, value []byte, userMeta byte,
+	casCounter uint64) error {
+	e := &badger.Entry{
+		Key:             key,
+		Value:           value,
+		UserMeta:        userMeta,
+		CASCounterCheck: casCounter,
+	}
+	if err := kv.BatchSet([]*badger.Entry{e}); err != nil {
+		return err
+	}
+
+	return e.Error
+}
+
+func (t *Table) runReaper() {
+	defer func() {
+		if r := recover(); r != nil {
+			t.db.log("cete: reaper panic:", r)
+		}
+	}()
+
+	for {
+		select {
+		case <-t.db.done:
+			return
+		case <-time.After(reapInterval):
+		}
+
+		err := t.reap()
+		if err == errTableClosed {
+			return
+		} else if err != nil {
+			t.db.log("cete: error while deleting expired documents:", err)
+		}
+	}
+}
+
+// reap deletes the expired documents in the table, and removes them from
+// the table's indexes.
+func (t *Table) reap() error {
+	t.db.commitLock.RLock()
+	defer t.db.commitLock.RUnlock()
+
+	if atomic.LoadInt32(&t.db.closed) != 0 ||
+		atomic.LoadInt32(&t.dropped) != 0 {
+		return errTableClosed
+	}
+
+	// Expired documents can't be deleted until the table's index functions
+	// have been registered.
+	if t.checkFuncs() != nil {
+		return nil
+	}
+
+	itOpts := badger.DefaultIteratorOptions
+	itOpts.PrefetchSize = prefetchSize
+	itOpts.PrefetchValues = false
+	it := t.data.NewIterator(itOpts)
+
+	var keys []string
+	var counters []uint64
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		if itemExpired(it.Item()) {
+			keys = append(keys, string(it.Item().Key()))
+			counters = append(counters, it.Item().Counter())
+		}
+	}
+
+	it.Close()
+
+	for i, key := range keys {
+		err := t.delete(key, counters[i])
+		if err != nil && err != ErrCounterChanged {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/1lann/badger"
+)
+
+func TestTTL(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("ttl_testing"))
+	table := db.Table("ttl_testing")
+	panicNotNil(table.NewUniqueIndex("Name"))
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", Age: 19}))
+	panicNotNil(table.SetWithTTL("ben", Person{Name: "Ben", Age: 20},
+		time.Millisecond*200))
+	panicNotNil(table.SetWithTTL("drew", Person{Name: "Drew", Age: 21},
+		time.Hour))
+
+	var person Person
+	_, err = table.Get("ben", &person)
+	panicNotNil(err)
+	if person.Name != "Ben" {
+		t.Fatal("name should be Ben, but is", person.Name)
+	}
+
+	time.Sleep(time.Millisecond * 300)
+
+	_, err = table.Get("ben", &person)
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	count, err := table.All().Count()
+	panicNotNil(err)
+	if count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+
+	if count := table.CountBetween(MinValue, MaxValue); count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+
+	_, _, err = table.Index("Name").One("ben", nil)
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	// The expired document should not prevent its unique index value from
+	// being taken.
+	panicNotNil(table.Set("ben2", Person{Name: "Ben"}))
+
+	err = table.Update("ben", func(p Person) (Person, error) {
+		return p, nil
+	})
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	panicNotNil(table.reap())
+
+	if count := table.countBetween(MinValue, MaxValue, true); count != 3 {
+		t.Fatal("count should be 3, but is", count)
+	}
+
+	report, err := table.Index("Name").Verify()
+	panicNotNil(err)
+	if !report.OK() {
+		t.Fatalf("report should be ok, but isn't: %+v", report)
+	}
+
+	panicNotNil(table.SetDefaultTTL(time.Millisecond * 200))
+	if table.DefaultTTL() != time.Millisecond*200 {
+		t.Fatal("default TTL should be 200ms, but is", table.DefaultTTL())
+	}
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("ttl_testing")
+	if table.DefaultTTL() != time.Millisecond*200 {
+		t.Fatal("default TTL should be 200ms, but is", table.DefaultTTL())
+	}
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", Age: 19}))
+	panicNotNil(db.Txn(func(tx *Tx) error {
+		return tx.Set("ttl_testing", "ben2", Person{Name: "Ben"})
+	}))
+	panicNotNil(table.SetWithTTL("drew", Person{Name: "Drew", Age: 21}, 0))
+
+	time.Sleep(time.Millisecond * 300)
+
+	counter, err := table.Get("jason", nil)
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", Age: 20}, counter))
+
+	_, err = table.Get("ben2", nil)
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	_, err = table.Get("drew", nil)
+	panicNotNil(err)
+
+	panicNotNil(table.reap())
+
+	if count := table.countBetween(MinValue, MaxValue, true); count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+
+	if count := table.Index("Name").CountBetween(MinValue, MaxValue); count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+}
+
+func TestUpdateKeepsTTL(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("update_ttl_testing"))
+	table := db.Table("update_ttl_testing")
+
+	expiry := func(key string) int64 {
+		var item badger.KVItem
+		panicNotNil(table.data.Get([]byte(key), &item))
+		return itemExpiry(&item)
+	}
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", Age: 19}))
+	panicNotNil(table.SetWithTTL("ben", Person{Name: "Ben", Age: 20},
+		time.Hour))
+	benExpiry := expiry("ben")
+
+	panicNotNil(table.SetDefaultTTL(time.Minute))
+
+	panicNotNil(table.Update("ben", func(p Person) (Person, error) {
+		p.Age++
+		return p, nil
+	}))
+
+	if expiry("ben") != benExpiry {
+		t.Fatal("expiry should be", benExpiry, "but is", expiry("ben"))
+	}
+
+	panicNotNil(NewTypedTable[Person](table).Update("ben",
+		func(p Person) (Person, error) {
+			p.Age++
+			return p, nil
+		}))
+
+	if expiry("ben") != benExpiry {
+		t.Fatal("expiry should be", benExpiry, "but is", expiry("ben"))
+	}
+
+	panicNotNil(table.Update("jason", func(p Person) (Person, error) {
+		p.Age++
+		return p, nil
+	}))
+
+	if expiry("jason") != 0 {
+		t.Fatal("jason should not expire, but expires at", expiry("jason"))
+	}
+
+	panicNotNil(table.SetWithTTL("drew", Person{Name: "Drew"},
+		time.Millisecond*200))
+	panicNotNil(table.Update("drew", func(p Person) (Person, error) {
+		p.Age = 21
+		return p, nil
+	}))
+
+	time.Sleep(time.Millisecond * 300)
+
+	if _, err = table.Get("drew", nil); err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+}
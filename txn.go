@@ -0,0 +1,301 @@
+package cete
+
+import (
+	"github.com/1lann/badger"
+	"github.com/1lann/msgpack"
+)
+
+// Tx represents a transaction, which can read and write documents across
+// multiple tables. A Tx is only valid for the duration of the function passed
+// to DB.Txn, and must not be used concurrently.
+type Tx struct {
+	db      *DB
+	entries map[Name]map[string]*txEntry
+
+	// accessed is the entries in the order they were first accessed, and
+	// writes is the written entries in the order they were first written,
+	// which is the order the writes are committed in.
+	accessed []*txEntry
+	writes   []*txEntry
+}
+
+type txEntry struct {
+	table   Name
+	key     string
+	counter uint64
+	data    []byte
+	written bool
+}
+
+// Txn runs fn in a transaction. All of the writes made through the Tx are
+// committed atomically, together with their index updates, if fn returns a
+// nil error. If fn returns a non-nil error, the writes are discarded and the
+// error is returned from Txn. ErrUniqueViolation will be returned if the
+// writes would violate a unique index. The writes are committed, and their
+// events are sent to watchers, in the order each document was first written.
+//
+// If any document read or written by the transaction is modified by someone
+// else before the transaction commits, fn will be called again with a new Tx,
+// similar to Update. As such, fn may be called more than once.
+//
+// Writes are recorded in a journal before they are applied, so a crash
+// during a commit will be recovered from the next time the database is opened.
+// If the writes are applied but the indexes fail to update, an IndexError is
+// returned, and the indexes are repaired the next time the database is
+// opened. Values which can't be indexed, such as maps, are left out of their
+// index, and an IndexError wrapping ErrUnsupportedValue is returned after
+// the transaction has been committed.
+func (d *DB) Txn(fn func(tx *Tx) error) error {
+	if d.readOnly {
+		return ErrReadOnly
+	}
+
+	for {
+		tx := &Tx{
+			db:      d,
+			entries: make(map[Name]map[string]*txEntry),
+		}
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		err := tx.commit()
+		if err == ErrCounterChanged {
+			continue
+		}
+
+		return err
+	}
+}
+
+func (tx *Tx) table(tableName string) (*Table, error) {
+	t := tx.db.Table(tableName)
+	if t == nil {
+		return nil, ErrNotFound
+	}
+
+	return t, nil
+}
+
+// entry returns the transaction's view of the given document, reading it
+// from the table the first time the document is accessed.
+func (tx *Tx) entry(t *Table, tableName, key string) (*txEntry, error) {
+	keys, found := tx.entries[Name(tableName)]
+	if !found {
+		keys = make(map[string]*txEntry)
+		tx.entries[Name(tableName)] = keys
+	}
+
+	if e, found := keys[key]; found {
+		return e, nil
+	}
+
+	var item badger.KVItem
+	err := t.data.Get([]byte(key), &item)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &txEntry{table: Name(tableName), key: key}
+
+	itemValue := getItemValue(&item)
+	if itemValue != nil && !itemExpired(&item) {
+		e.counter = item.Counter()
+		e.data = make([]byte, len(itemValue))
+		copy(e.data, itemValue)
+	}
+
+	keys[key] = e
+	tx.accessed = append(tx.accessed, e)
+	return e, nil
+}
+
+// Get retrieves a document from a table in the transaction. Writes made
+// earlier in the transaction are visible to Get. dst must either be a pointer
+// or nil if you only want to get the counter or check for existence. The
+// counter returned is the counter of the document when it was first read
+// by the transaction.
+func (tx *Tx) Get(tableName, key string, dst interface{}) (uint64, error) {
+	t, err := tx.table(tableName)
+	if err != nil {
+		return 0, err
+	}
+
+	e, err := tx.entry(t, tableName, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if e.data == nil {
+		return 0, ErrNotFound
+	}
+
+	if dst == nil {
+		return e.counter, nil
+	}
+
+	return e.counter, Document{data: e.data, table: t}.Decode(dst)
+}
+
+// Set sets a document in a table in the transaction. An optional counter value
+// can be provided to only set the value if the counter value is the same as
+// when the document was first read by the transaction. A counter value
+// of 0 represents a key that doesn't exist.
+func (tx *Tx) Set(tableName, key string, value interface{},
+	counter ...uint64) error {
+	t, err := tx.table(tableName)
+	if err != nil {
+		return err
+	}
+
+	e, err := tx.entry(t, tableName, key)
+	if err != nil {
+		return err
+	}
+
+	if len(counter) > 0 && e.counter != counter[0] {
+		return ErrCounterChanged
+	}
+
+	var data []byte
+	if t.keyToCompressed != nil {
+		data, err = msgpack.MarshalCompressed(t.keyToC, value)
+	} else {
+		data, err = msgpack.Marshal(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.data = data
+	tx.write(e)
+
+	return nil
+}
+
+// Delete deletes a document from a table in the transaction. An optional
+// counter value can be provided to only delete the document if the counter
+// value is the same as when the document was first read by the transaction.
+func (tx *Tx) Delete(tableName, key string, counter ...uint64) error {
+	t, err := tx.table(tableName)
+	if err != nil {
+		return err
+	}
+
+	e, err := tx.entry(t, tableName, key)
+	if err != nil {
+		return err
+	}
+
+	if len(counter) > 0 && e.counter != counter[0] {
+		return ErrCounterChanged
+	}
+
+	e.data = nil
+	tx.write(e)
+
+	return nil
+}
+
+// write marks the entry as written.
+func (tx *Tx) write(e *txEntry) {
+	if !e.written {
+		e.written = true
+		tx.writes = append(tx.writes, e)
+	}
+}
+
+func (tx *Tx) commit() error {
+	tx.db.commitLock.Lock()
+	defer tx.db.commitLock.Unlock()
+
+	olds := make(map[*txEntry][]byte)
+	var item badger.KVItem
+
+	for _, e := range tx.accessed {
+		t := tx.db.tables[e.table]
+		if t == nil {
+			return ErrNotFound
+		}
+
+		if err := t.checkFuncs(); err != nil {
+			return err
+		}
+
+		err := t.data.Get([]byte(e.key), &item)
+		if err != nil {
+			return err
+		}
+
+		var counter uint64
+
+		// The old value of an expired document is still needed to
+		// remove it from the indexes.
+		itemValue := getItemValue(&item)
+		if itemValue != nil {
+			if !itemExpired(&item) {
+				counter = item.Counter()
+			}
+			old := make([]byte, len(itemValue))
+			copy(old, itemValue)
+			olds[e] = old
+		}
+
+		if counter != e.counter {
+			return ErrCounterChanged
+		}
+	}
+
+	var entries []journalEntry
+	var counters []uint64
+
+	for _, e := range tx.writes {
+		old := olds[e]
+		if old == nil && e.data == nil {
+			continue
+		}
+
+		var expires int64
+		if e.data != nil {
+			expires = tx.db.tables[e.table].defaultExpiry()
+		}
+
+		entries = append(entries, journalEntry{
+			Table:   string(e.table),
+			Key:     e.key,
+			Old:     old,
+			New:     e.data,
+			Expires: expires,
+		})
+		counters = append(counters, e.counter)
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := tx.db.checkUnique(entries); err != nil {
+		return err
+	}
+
+	skipped, err := tx.db.commitJournal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Other writes are blocked by the commit lock, so the counters of the
+	// written documents can't change before they're read.
+	for i, entry := range entries {
+		t := tx.db.tables[Name(entry.Table)]
+
+		var newCounter uint64
+		if entry.New != nil {
+			newCounter = t.writtenCounter(entry.Key)
+		}
+
+		t.notify(entry.Key, entry.Old, entry.New, counters[i], newCounter)
+	}
+
+	return skipped
+}
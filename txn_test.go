@@ -0,0 +1,303 @@
+package cete
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/1lann/msgpack"
+)
+
+func TestTxn(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("txn_people"))
+	panicNotNil(db.NewTable("txn_counters", false))
+	panicNotNil(db.Table("txn_people").NewIndex("Age"))
+
+	panicNotNil(db.Table("txn_people").Set("jason", Person{
+		Name: "Jason",
+		Age:  18,
+	}))
+
+	err = db.Txn(func(tx *Tx) error {
+		var person Person
+		_, txErr := tx.Get("txn_people", "jason", &person)
+		if txErr != nil {
+			return txErr
+		}
+
+		person.Age = 19
+		if txErr = tx.Set("txn_people", "jason", person); txErr != nil {
+			return txErr
+		}
+
+		if txErr = tx.Set("txn_people", "ben", Person{
+			Name: "Ben",
+			Age:  19,
+		}); txErr != nil {
+			return txErr
+		}
+
+		_, txErr = tx.Get("txn_people", "jason", &person)
+		if txErr != nil {
+			return txErr
+		}
+
+		if person.Age != 19 {
+			t.Fatal("transaction should see its own writes, but doesn't")
+		}
+
+		return tx.Set("txn_counters", "people", Counter{Count: 2})
+	})
+	panicNotNil(err)
+
+	count := db.Table("txn_people").Index("Age").CountBetween(19, 19)
+	if count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+
+	count = db.Table("txn_people").Index("Age").CountBetween(18, 18)
+	if count != 0 {
+		t.Fatal("count should be 0, but is", count)
+	}
+
+	var counter Counter
+	_, err = db.Table("txn_counters").Get("people", &counter)
+	panicNotNil(err)
+
+	if counter.Count != 2 {
+		t.Fatal("counter should be 2, but is", counter.Count)
+	}
+
+	abort := errors.New("abort")
+	err = db.Txn(func(tx *Tx) error {
+		if txErr := tx.Delete("txn_people", "jason"); txErr != nil {
+			return txErr
+		}
+
+		return abort
+	})
+	if err != abort {
+		t.Fatal("error should be abort, but is", err)
+	}
+
+	_, err = db.Table("txn_people").Get("jason", nil)
+	panicNotNil(err)
+
+	err = db.Txn(func(tx *Tx) error {
+		return tx.Set("does not exist", "key", "value")
+	})
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	err = db.Txn(func(tx *Tx) error {
+		return tx.Set("txn_people", "jason", Person{}, 1000)
+	})
+	if err != ErrCounterChanged {
+		t.Fatal("error should be ErrCounterChanged, but is", err)
+	}
+
+	panicNotNil(db.Table("txn_counters").Set("a", Counter{Count: 100}))
+	panicNotNil(db.Table("txn_counters").Set("b", Counter{Count: 0}))
+
+	wg := new(sync.WaitGroup)
+	wg.Add(50)
+
+	for i := 0; i < 50; i++ {
+		go func() {
+			defer wg.Done()
+
+			txErr := db.Txn(func(tx *Tx) error {
+				var a, b Counter
+				if _, err := tx.Get("txn_counters", "a", &a); err != nil {
+					return err
+				}
+				if _, err := tx.Get("txn_counters", "b", &b); err != nil {
+					return err
+				}
+
+				a.Count--
+				b.Count++
+
+				if err := tx.Set("txn_counters", "a", a); err != nil {
+					return err
+				}
+				return tx.Set("txn_counters", "b", b)
+			})
+			panicNotNil(txErr)
+		}()
+	}
+
+	wg.Wait()
+
+	var a, b Counter
+	_, err = db.Table("txn_counters").Get("a", &a)
+	panicNotNil(err)
+	_, err = db.Table("txn_counters").Get("b", &b)
+	panicNotNil(err)
+
+	if a.Count != 50 || b.Count != 50 {
+		t.Fatal("counts should be 50 and 50, but are", a.Count, b.Count)
+	}
+}
+
+func TestTxnRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("txn_recovery", false))
+	panicNotNil(db.Table("txn_recovery").NewIndex("Age"))
+	panicNotNil(db.Table("txn_recovery").Set("jason", Person{
+		Name: "Jason",
+		Age:  18,
+	}))
+
+	old, err := msgpack.Marshal(Person{Name: "Jason", Age: 18})
+	panicNotNil(err)
+	updated, err := msgpack.Marshal(Person{Name: "Jason", Age: 20})
+	panicNotNil(err)
+	created, err := msgpack.Marshal(Person{Name: "Ben", Age: 20})
+	panicNotNil(err)
+
+	// Simulate a crash after the journal was written, but before
+	// all of the writes were applied.
+	data, err := msgpack.Marshal([]journalEntry{
+		{Table: "txn_recovery", Key: "jason", Old: old, New: updated},
+		{Table: "txn_recovery", Key: "ben", New: created},
+	})
+	panicNotNil(err)
+
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, 1)
+	panicNotNil(db.journal.Set(seq, data, 0))
+	panicNotNil(db.Table("txn_recovery").data.Set([]byte("jason"), updated, 0))
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	count := db.Table("txn_recovery").Index("Age").CountBetween(20, 20)
+	if count != 2 {
+		t.Fatal("count should be 2, but is", count)
+	}
+
+	count = db.Table("txn_recovery").Index("Age").CountBetween(18, 18)
+	if count != 0 {
+		t.Fatal("count should be 0, but is", count)
+	}
+
+	var person Person
+	_, err = db.Table("txn_recovery").Get("ben", &person)
+	panicNotNil(err)
+
+	if person.Age != 20 {
+		t.Fatal("age should be 20, but is", person.Age)
+	}
+
+	exists, err := db.journal.Exists(seq)
+	panicNotNil(err)
+	if exists {
+		t.Fatal("journal entry should have been removed, but hasn't")
+	}
+}
+
+func TestTxnIndexErrorRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("txn_index_error", false))
+	table := db.Table("txn_index_error")
+	panicNotNil(table.NewIndex("Age"))
+	panicNotNil(table.Set("a", Person{Name: "A", Age: 1}))
+
+	// Remove a from the index, so that the transaction fails to update it.
+	panicNotNil(table.Index("Age").deleteFromIndex(valueToBytes(int64(1)), "a"))
+
+	err = db.Txn(func(tx *Tx) error {
+		return tx.Set("txn_index_error", "a", Person{Name: "A", Age: 2})
+	})
+	if !errors.Is(err, ErrCorruptIndex) {
+		t.Fatal("error should be ErrCorruptIndex, but is", err)
+	}
+
+	panicNotNil(table.Set("a", Person{Name: "A", Age: 3}))
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("txn_index_error")
+
+	var person Person
+	_, err = table.Get("a", &person)
+	panicNotNil(err)
+
+	if person.Age != 3 {
+		t.Fatal("age should be 3, but is", person.Age)
+	}
+
+	index := table.Index("Age")
+	if count := index.CountBetween(MinValue, MaxValue); count != 1 {
+		t.Fatal("count should be 1, but is", count)
+	}
+
+	if key, _, err := index.One(3, nil); err != nil || key != "a" {
+		t.Fatal("a should be indexed with 3, but isn't:", key, err)
+	}
+}
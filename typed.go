@@ -0,0 +1,238 @@
+package cete
+
+import (
+	"iter"
+	"time"
+)
+
+// TypedTable is a type-safe view of a table whose documents are all of
+// type T.
+type TypedTable[T any] struct {
+	table *Table
+}
+
+// TypedIndex is a type-safe view of an index of a TypedTable.
+type TypedIndex[T any] struct {
+	index *Index
+}
+
+// TypedRange is a type-safe Range, which decodes each document into a T.
+type TypedRange[T any] struct {
+	r     *Range
+	value T
+	err   error
+}
+
+// NewTypedTable returns a type-safe view of the table, whose documents are
+// all of type T.
+func NewTypedTable[T any](t *Table) *TypedTable[T] {
+	return &TypedTable[T]{table: t}
+}
+
+// Table returns the underlying table.
+func (t *TypedTable[T]) Table() *Table {
+	return t.table
+}
+
+// Get retrieves a document from the table with its primary key, and returns
+// it with its counter.
+func (t *TypedTable[T]) Get(key string) (T, uint64, error) {
+	var value T
+	counter, err := t.table.Get(key, &value)
+	return value, counter, err
+}
+
+// Set sets a document in the table. See Table.Set for details.
+func (t *TypedTable[T]) Set(key string, value T, counter ...uint64) error {
+	return t.table.Set(key, value, counter...)
+}
+
+// SetWithTTL sets a document in the table which expires after the given
+// duration. See Table.SetWithTTL for details.
+func (t *TypedTable[T]) SetWithTTL(key string, value T, ttl time.Duration,
+	counter ...uint64) error {
+	return t.table.SetWithTTL(key, value, ttl, counter...)
+}
+
+// Delete deletes the document from the table. See Table.Delete for details.
+func (t *TypedTable[T]) Delete(key string, counter ...uint64) error {
+	return t.table.Delete(key, counter...)
+}
+
+// Update updates a document in the table with the given handler, which
+// returns the new value of the document, or an error to abort the update.
+// Like Table.Update, the handler will be called again if the document is
+// modified before the update is written, and ErrNotFound will be returned
+// if the document does not exist. The document keeps its expiry time.
+func (t *TypedTable[T]) Update(key string, handler func(T) (T, error)) error {
+	for {
+		value, counter, err := t.Get(key)
+		if err != nil {
+			return err
+		}
+
+		value, err = handler(value)
+		if err != nil {
+			return err
+		}
+
+		expires, err := t.table.currentExpiry(key, counter)
+		if err == ErrCounterChanged {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = t.table.set(key, value, expires, counter)
+		if err == ErrCounterChanged {
+			continue
+		}
+
+		return err
+	}
+}
+
+// Between returns a TypedRange of documents between the lower and upper key
+// values provided. See Table.Between for details.
+func (t *TypedTable[T]) Between(lower, upper interface{},
+	reverse ...bool) *TypedRange[T] {
+	return &TypedRange[T]{r: t.table.Between(lower, upper, reverse...)}
+}
+
+// All returns all of the documents in the table, sorted by key.
+func (t *TypedTable[T]) All() ([]T, error) {
+	return t.Between(MinValue, MaxValue).All()
+}
+
+// Index returns a type-safe view of an index of the table. If the index does
+// not exist, nil is returned.
+func (t *TypedTable[T]) Index(name string) *TypedIndex[T] {
+	index := t.table.Index(name)
+	if index == nil {
+		return nil
+	}
+
+	return &TypedIndex[T]{index: index}
+}
+
+// Index returns the underlying index.
+func (i *TypedIndex[T]) Index() *Index {
+	return i.index
+}
+
+// One returns the first document with the given index value, along with its
+// key and counter. See Index.One for details.
+func (i *TypedIndex[T]) One(value interface{}) (T, string, uint64, error) {
+	var doc T
+	key, counter, err := i.index.One(value, &doc)
+	return doc, key, counter, err
+}
+
+// GetAll returns a TypedRange of all the documents with the given index
+// value.
+func (i *TypedIndex[T]) GetAll(value interface{}) *TypedRange[T] {
+	return &TypedRange[T]{r: i.index.GetAll(value)}
+}
+
+// Between returns a TypedRange of documents between the lower and upper
+// index values provided. See Index.Between for details.
+func (i *TypedIndex[T]) Between(lower, upper interface{},
+	reverse ...bool) *TypedRange[T] {
+	return &TypedRange[T]{r: i.index.Between(lower, upper, reverse...)}
+}
+
+// All returns a TypedRange of all the documents which have an index value.
+func (i *TypedIndex[T]) All(reverse ...bool) *TypedRange[T] {
+	return i.Between(MinValue, MaxValue, reverse...)
+}
+
+// Next retrieves and decodes the next document in the range, and returns
+// true if it was successful.
+func (r *TypedRange[T]) Next() bool {
+	if r.err != nil || !r.r.Next() {
+		return false
+	}
+
+	var value T
+	if err := r.r.Decode(&value); err != nil {
+		r.err = err
+		r.r.Close()
+		return false
+	}
+
+	r.value = value
+	return true
+}
+
+// Value returns the current document.
+func (r *TypedRange[T]) Value() T {
+	return r.value
+}
+
+// Key returns the key of the current document.
+func (r *TypedRange[T]) Key() string {
+	return r.r.Key()
+}
+
+// Counter returns the counter of the current document.
+func (r *TypedRange[T]) Counter() uint64 {
+	return r.r.Counter()
+}
+
+// Error returns the last error causing Next to return false. Like
+// Range.Error, it is ErrEndOfRange if the end of the range was reached.
+func (r *TypedRange[T]) Error() error {
+	if r.err != nil {
+		return r.err
+	}
+
+	return r.r.Error()
+}
+
+// Seq2 returns an iterator over the keys and documents of the range. If a
+// document fails to decode, the loop is stopped and the error is returned
+// by Error. See Range.Seq2 for details.
+func (r *TypedRange[T]) Seq2() iter.Seq2[string, T] {
+	return func(yield func(string, T) bool) {
+		for key, doc := range r.r.Seq2() {
+			var value T
+			if err := doc.Decode(&value); err != nil {
+				r.err = err
+				return
+			}
+
+			if !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Seq returns an iterator over the documents of the range. See Seq2 for
+// details.
+func (r *TypedRange[T]) Seq() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for _, value := range r.Seq2() {
+			if !yield(value) {
+				return
+			}
+		}
+	}
+}
+
+// All returns the remaining documents in the range.
+func (r *TypedRange[T]) All() ([]T, error) {
+	var values []T
+	err := r.r.All(&values)
+	return values, err
+}
+
+// Close closes the range.
+func (r *TypedRange[T]) Close() {
+	r.r.Close()
+}
+
+// Range returns the underlying Range.
+func (r *TypedRange[T]) Range() *Range {
+	return r.r
+}
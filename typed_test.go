@@ -0,0 +1,104 @@
+package cete
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTypedTable(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("typed_testing"))
+	panicNotNil(db.Table("typed_testing").NewIndex("Age"))
+
+	people := NewTypedTable[Person](db.Table("typed_testing"))
+	panicNotNil(people.Set("jason", Person{Name: "Jason", Age: 19}))
+	panicNotNil(people.Set("ben", Person{Name: "Ben", Age: 20}))
+	panicNotNil(people.Set("drew", Person{Name: "Drew", Age: 21}))
+
+	person, counter, err := people.Get("jason")
+	panicNotNil(err)
+	if person.Name != "Jason" || counter == 0 {
+		t.Fatalf("person should be Jason with a counter, but is %+v with %d",
+			person, counter)
+	}
+
+	_, _, err = people.Get("nobody")
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	panicNotNil(people.Update("jason", func(p Person) (Person, error) {
+		p.Age++
+		return p, nil
+	}))
+
+	errAbort := errors.New("abort")
+	err = people.Update("jason", func(p Person) (Person, error) {
+		return p, errAbort
+	})
+	if err != errAbort {
+		t.Fatal("error should be errAbort, but is", err)
+	}
+
+	all, err := people.All()
+	panicNotNil(err)
+	if len(all) != 3 || all[0].Name != "Ben" || all[1].Name != "Drew" ||
+		all[2].Name != "Jason" || all[2].Age != 20 {
+		t.Fatalf("all should be Ben, Drew and Jason, but is %+v", all)
+	}
+
+	r := people.Index("Age").Between(20, MaxValue, true)
+	var names []string
+	for r.Next() {
+		names = append(names, r.Key()+":"+r.Value().Name)
+	}
+
+	if r.Error() != ErrEndOfRange {
+		t.Fatal("error should be ErrEndOfRange, but is", r.Error())
+	}
+
+	if len(names) != 3 || names[0] != "drew:Drew" {
+		t.Fatal("names should start with drew:Drew, but are", names)
+	}
+
+	person, key, _, err := people.Index("Age").One(21)
+	panicNotNil(err)
+	if key != "drew" || person.Name != "Drew" {
+		t.Fatalf("person should be drew, but is %s: %+v", key, person)
+	}
+
+	if people.Index("Name") != nil {
+		t.Fatal("index should be nil, but isn't")
+	}
+
+	panicNotNil(db.Table("typed_testing").Set("bad", "not a person"))
+
+	r = people.Between("bad", "bad")
+	if r.Next() {
+		t.Fatal("next should be false, but is true")
+	}
+
+	if r.Error() == nil || r.Error() == ErrEndOfRange {
+		t.Fatal("error should be a decode error, but is", r.Error())
+	}
+}
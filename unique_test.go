@@ -0,0 +1,206 @@
+package cete
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUniqueIndex(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	panicNotNil(db.NewTable("unique_testing"))
+	table := db.Table("unique_testing")
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", City: "Sydney"}))
+	panicNotNil(table.Set("ben", Person{Name: "Ben", City: "Sydney"}))
+
+	err = table.NewUniqueIndex("City")
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	if table.Index("City") != nil {
+		t.Fatal("index should not exist, but does")
+	}
+
+	panicNotNil(table.NewUniqueIndex("Name"))
+
+	err = table.Set("drew", Person{Name: "jason", City: "London"})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	_, err = table.Get("drew", nil)
+	if err != ErrNotFound {
+		t.Fatal("error should be ErrNotFound, but is", err)
+	}
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", City: "London"}))
+
+	err = table.Update("ben", func(p Person) (Person, error) {
+		p.Name = "Jason"
+		return p, nil
+	})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	err = db.Txn(func(tx *Tx) error {
+		if txErr := tx.Set("unique_testing", "jason",
+			Person{Name: "Ben"}); txErr != nil {
+			return txErr
+		}
+
+		return tx.Set("unique_testing", "ben", Person{Name: "Jason"})
+	})
+	panicNotNil(err)
+
+	var person Person
+	_, _, err = table.Index("Name").One("ben", &person)
+	panicNotNil(err)
+	if person.Name != "Ben" {
+		t.Fatal("name should be Ben, but is", person.Name)
+	}
+
+	err = db.Txn(func(tx *Tx) error {
+		if txErr := tx.Set("unique_testing", "a",
+			Person{Name: "Drew"}); txErr != nil {
+			return txErr
+		}
+
+		return tx.Set("unique_testing", "b", Person{Name: "Drew"})
+	})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	db.Close()
+
+	db, err = Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	table = db.Table("unique_testing")
+
+	err = table.Set("drew", Person{Name: "Ben"})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	panicNotNil(table.Delete("ben"))
+	panicNotNil(table.Set("drew", Person{Name: "Jason"}))
+
+	var successes int32
+	wg := new(sync.WaitGroup)
+	wg.Add(20)
+
+	for i := 0; i < 20; i++ {
+		go func(i int) {
+			defer wg.Done()
+			setErr := table.Set("racer"+strconv.Itoa(i), Person{Name: "Racer"})
+			if setErr == nil {
+				atomic.AddInt32(&successes, 1)
+			} else if setErr != ErrUniqueViolation {
+				panic(setErr)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if successes != 1 {
+		t.Fatal("there should be 1 success, but there were", successes)
+	}
+
+	if count := table.Index("Name").CountBetween("racer", "racer"); count != 1 {
+		t.Fatal("count should be 1, but is", count)
+	}
+}
+
+type uniqueAccount struct {
+	Email *string
+}
+
+func TestUniqueIndexNil(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("unique_nil_testing"))
+	table := db.Table("unique_nil_testing")
+
+	email := "jason@example.com"
+
+	panicNotNil(table.Set("jason", uniqueAccount{Email: &email}))
+	panicNotNil(table.Set("ben", uniqueAccount{}))
+	panicNotNil(table.Set("drew", uniqueAccount{}))
+
+	// Documents with a nil value don't conflict when the index is built.
+	panicNotNil(table.NewUniqueIndex("Email"))
+
+	panicNotNil(table.Set("alex", uniqueAccount{}))
+
+	err = db.Txn(func(tx *Tx) error {
+		if txErr := tx.Set("unique_nil_testing", "jason",
+			uniqueAccount{}); txErr != nil {
+			return txErr
+		}
+
+		return tx.Set("unique_nil_testing", "sam", uniqueAccount{})
+	})
+	panicNotNil(err)
+
+	if count := table.Index("Email").CountBetween(nil, nil); count != 5 {
+		t.Fatal("count should be 5, but is", count)
+	}
+
+	panicNotNil(table.Set("ben", uniqueAccount{Email: &email}))
+
+	err = table.Set("drew", uniqueAccount{Email: &email})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+
+	err = db.Txn(func(tx *Tx) error {
+		return tx.Set("unique_nil_testing", "alex",
+			uniqueAccount{Email: &email})
+	})
+	if err != ErrUniqueViolation {
+		t.Fatal("error should be ErrUniqueViolation, but is", err)
+	}
+}
@@ -0,0 +1,140 @@
+package cete
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndexValues(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("values_testing"))
+	table := db.Table("values_testing")
+	panicNotNil(table.NewIndex("City"))
+	panicNotNil(table.NewIndex("Likes.*"))
+	panicNotNil(table.NewIndex("City,Age"))
+	panicNotNil(table.NewIndex("Locale", IndexOptions{
+		Query:     "City",
+		Collation: Collation{Locale: "en"},
+	}))
+
+	people := map[string]Person{
+		"ben":   {Name: "Ben", City: "Melbourne", Age: 19, Likes: []string{"c", "go"}},
+		"drew":  {Name: "Drew", City: "London", Age: 17, Likes: []string{"js"}},
+		"jason": {Name: "Jason", City: "Sydney", Age: 18, Likes: []string{"go", "js"}},
+		"kevin": {Name: "Kevin", City: "Sydney", Age: 25, Likes: []string{"rust"}},
+		"mark":  {Name: "Mark", City: "Sydney", Age: 16, Likes: []string{"go"}},
+	}
+
+	for key, person := range people {
+		panicNotNil(table.Set(key, person))
+	}
+
+	expectValues := func(buckets []Bucket, err error, expected ...Bucket) {
+		t.Helper()
+		panicNotNil(err)
+
+		if !reflect.DeepEqual(buckets, expected) {
+			t.Fatal("values should be", expected, "but are", buckets)
+		}
+	}
+
+	cities := table.Index("City")
+	buckets, err := cities.Values(MinValue, MaxValue)
+	expectValues(buckets, err, Bucket{"london", 1}, Bucket{"melbourne", 1},
+		Bucket{"sydney", 3})
+
+	buckets, err = cities.Values("m", MaxValue, true)
+	expectValues(buckets, err, Bucket{"sydney", 3}, Bucket{"melbourne", 1})
+
+	buckets, err = table.Index("Likes.*").Values(MinValue, "js", true)
+	expectValues(buckets, err, Bucket{"js", 2}, Bucket{"go", 3},
+		Bucket{"c", 1})
+
+	buckets, err = table.Index("City,Age").Values(
+		[]interface{}{"Sydney", 17}, []interface{}{"Sydney", MaxValue})
+	expectValues(buckets, err,
+		Bucket{[]interface{}{"sydney", int64(18)}, 1},
+		Bucket{[]interface{}{"sydney", int64(25)}, 1})
+
+	buckets, err = table.Index("Locale").Values(MinValue, MaxValue)
+	expectValues(buckets, err, Bucket{"London", 1}, Bucket{"Melbourne", 1},
+		Bucket{"Sydney", 3})
+
+	panicNotNil(db.NewTable("scores_testing"))
+	scores := db.Table("scores_testing")
+	panicNotNil(scores.NewIndex("Score"))
+
+	values := []interface{}{
+		int64(-100), -2.5, int64(0), 1.25, int64(7), uint64(math.MaxUint64),
+		time.Date(1969, 7, 20, 20, 17, 40, 5, time.UTC),
+		time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC), false, true, nil,
+	}
+
+	for n, value := range values {
+		panicNotNil(scores.Set(paddedItoa(n), encodingScore{Score: value}))
+	}
+
+	// Whole numbers are decoded as integers, as they have the same index
+	// value as floats.
+	panicNotNil(scores.Set("float", encodingScore{Score: 0.0}))
+
+	buckets, err = scores.Index("Score").Values(MinValue, MaxValue)
+	panicNotNil(err)
+
+	if len(buckets) != len(values) {
+		t.Fatal("there should be", len(values), "values, but there are",
+			buckets)
+	}
+
+	for n, bucket := range buckets {
+		count := int64(1)
+		if n == 2 {
+			count = 2
+		}
+
+		if bucket.Value != values[n] || bucket.Count != count {
+			t.Fatal("value should be", values[n], "but is", bucket.Value)
+		}
+	}
+
+	// Times in other time zones are decoded in UTC.
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	panicNotNil(err)
+
+	launch := time.Date(2000, 1, 1, 12, 0, 0, 0, sydney)
+	panicNotNil(scores.Set("sydney", encodingScore{
+		Score: []interface{}{launch, "a"},
+	}))
+
+	buckets, err = scores.Index("Score").Values([]interface{}{launch},
+		[]interface{}{launch, MaxValue})
+	expectValues(buckets, err,
+		Bucket{[]interface{}{launch.UTC(), "a"}, 1})
+
+	if _, err = scores.Index("Score").Values(map[string]int{},
+		MaxValue); err != ErrUnsupportedValue {
+		t.Fatal("error should be ErrUnsupportedValue, but is", err)
+	}
+}
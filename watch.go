@@ -0,0 +1,263 @@
+package cete
+
+import (
+	"bytes"
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/1lann/badger"
+)
+
+// watchQueueLimit is the maximum number of events that can be waiting to be
+// received from a watch channel, before the watcher is stopped.
+const watchQueueLimit = 10000
+
+// keyLockCount is the number of locks that writes to documents are
+// serialized with.
+const keyLockCount = 64
+
+// Operation represents the type of change made to a document.
+type Operation int
+
+// Possible operations of an Event.
+const (
+	OpSet Operation = iota + 1
+	OpDelete
+)
+
+// Event represents a change made to a document in a table.
+type Event struct {
+	Table      string
+	Key        string
+	Op         Operation
+	OldCounter uint64
+	NewCounter uint64
+
+	// Document is the new value of the document. It is empty if the
+	// document was deleted.
+	Document Document
+}
+
+type watcher struct {
+	filter func(old, new []byte) bool
+
+	lock   *sync.Mutex
+	queue  []Event
+	notify chan struct{}
+	stop   chan struct{}
+}
+
+// Watch returns a channel of events for every change made to the documents
+// in the table, through Set, Delete, Update or a transaction. The events of
+// each document are delivered in the order they were made, and are buffered
+// so that writes to the table are never blocked by a slow receiver.
+//
+// The channel is closed when the context is done, or the table or database
+// is closed. It is also closed if the receiver falls more than 10000 events
+// behind, to bound the memory used by the buffer, so receivers which need
+// every event should watch again and reconcile when the channel is closed.
+func (t *Table) Watch(ctx context.Context) <-chan Event {
+	return t.watch(ctx, nil)
+}
+
+// Watch returns a channel of events for changes made to documents which had
+// or now have an index value within the given inclusive bounds. Like
+// Table.Watch, the channel is closed when the context is done. If either
+// bound is a value that can't be indexed, the channel is closed immediately.
+//
+// You can use cete.MinValue and cete.MaxValue to specify minimum and maximum
+// bound values.
+func (i *Index) Watch(ctx context.Context, lower, upper interface{}) <-chan Event {
+	lowerBytes, lowerErr := i.valueToBytes(lower)
+	upperBytes, upperErr := i.valueToBytes(upper)
+	if lowerErr != nil || upperErr != nil {
+		events := make(chan Event)
+		close(events)
+		return events
+	}
+
+	inRange := func(data []byte) bool {
+		if len(data) == 0 {
+			return false
+		}
+
+		results, err := i.values(data)
+		if err != nil {
+			return false
+		}
+
+		for _, value := range i.indexKeys(results) {
+			if (lower == MinValue || bytes.Compare(value, lowerBytes) >= 0) &&
+				(upper == MaxValue || bytes.Compare(value, upperBytes) <= 0) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return i.table.watch(ctx, func(old, new []byte) bool {
+		return inRange(old) || inRange(new)
+	})
+}
+
+func (t *Table) watch(ctx context.Context,
+	filter func(old, new []byte) bool) <-chan Event {
+	w := &watcher{
+		filter: filter,
+		lock:   new(sync.Mutex),
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+
+	t.watchLock.Lock()
+	t.watchers[w] = true
+	t.watchLock.Unlock()
+
+	out := make(chan Event)
+
+	go func() {
+		defer close(out)
+		defer func() {
+			t.watchLock.Lock()
+			delete(t.watchers, w)
+			t.watchLock.Unlock()
+		}()
+
+		for {
+			w.lock.Lock()
+			queue := w.queue
+			w.queue = nil
+			w.lock.Unlock()
+
+			for _, event := range queue {
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				case <-w.stop:
+					return
+				}
+			}
+
+			select {
+			case <-w.notify:
+			case <-ctx.Done():
+				return
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// notify sends an event to the table's watchers, for a document which
+// has changed from old to new.
+func (t *Table) notify(key string, old, new []byte, oldCounter,
+	newCounter uint64) {
+	t.watchLock.Lock()
+	defer t.watchLock.Unlock()
+
+	if len(t.watchers) == 0 {
+		return
+	}
+
+	event := Event{
+		Table:      t.name(),
+		Key:        key,
+		Op:         OpSet,
+		OldCounter: oldCounter,
+		NewCounter: newCounter,
+	}
+
+	if old == nil {
+		event.OldCounter = 0
+	}
+
+	if new == nil {
+		event.Op = OpDelete
+	} else {
+		event.Document = Document{
+			data:  new,
+			table: t,
+		}
+	}
+
+	for w := range t.watchers {
+		if w.filter != nil && !w.filter(old, new) {
+			continue
+		}
+
+		w.lock.Lock()
+		stalled := len(w.queue) >= watchQueueLimit
+		if !stalled {
+			w.queue = append(w.queue, event)
+		}
+		w.lock.Unlock()
+
+		// Rather than dropping events, the channel is closed so the receiver
+		// knows it has missed some.
+		if stalled {
+			close(w.stop)
+			delete(t.watchers, w)
+			continue
+		}
+
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// writtenCounter returns the counter of a document which was just written,
+// for its event. The stores don't return the counter of a write, so it's
+// read back while the document's key lock is still held, which prevents
+// the document from being written again in between. It returns 0 if the
+// table has no watchers.
+func (t *Table) writtenCounter(key string) uint64 {
+	t.watchLock.Lock()
+	watched := len(t.watchers) > 0
+	t.watchLock.Unlock()
+
+	if !watched {
+		return 0
+	}
+
+	var item badger.KVItem
+	if err := t.data.Get([]byte(key), &item); err != nil {
+		return 0
+	}
+
+	return item.Counter()
+}
+
+func newKeyLocks() []*sync.Mutex {
+	locks := make([]*sync.Mutex, keyLockCount)
+	for i := range locks {
+		locks[i] = new(sync.Mutex)
+	}
+
+	return locks
+}
+
+// keyLock returns the lock that writes to the document with the key must
+// hold.
+func (t *Table) keyLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return t.keyLocks[h.Sum32()%keyLockCount]
+}
+
+func (t *Table) stopWatchers() {
+	t.watchLock.Lock()
+	defer t.watchLock.Unlock()
+
+	for w := range t.watchers {
+		close(w.stop)
+		delete(t.watchers, w)
+	}
+}
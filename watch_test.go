@@ -0,0 +1,218 @@
+package cete
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events channel should be open, but is closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+
+	return Event{}
+}
+
+func TestWatch(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("watch_testing"))
+	table := db.Table("watch_testing")
+	panicNotNil(table.NewIndex("Age"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := table.Watch(ctx)
+	adults := table.Index("Age").Watch(context.Background(), 18, MaxValue)
+
+	panicNotNil(table.Set("jason", Person{Name: "Jason", Age: 12}))
+	panicNotNil(table.Set("ben", Person{Name: "Ben", Age: 19}))
+
+	event := receiveEvent(t, events)
+	if event.Table != "watch_testing" || event.Key != "jason" ||
+		event.Op != OpSet || event.OldCounter != 0 || event.NewCounter == 0 {
+		t.Fatalf("event should be a set of jason, but is: %+v", event)
+	}
+
+	jasonCounter := event.NewCounter
+
+	var person Person
+	panicNotNil(event.Document.Decode(&person))
+	if person.Name != "Jason" {
+		t.Fatal("name should be Jason, but is", person.Name)
+	}
+
+	event = receiveEvent(t, events)
+	if event.Key != "ben" || event.Op != OpSet {
+		t.Fatalf("event should be a set of ben, but is: %+v", event)
+	}
+
+	event = receiveEvent(t, adults)
+	benCounter := event.NewCounter
+	if event.Key != "ben" {
+		t.Fatal("key should be ben, but is", event.Key)
+	}
+
+	panicNotNil(table.Update("jason", func(p Person) (Person, error) {
+		p.Age = 20
+		return p, nil
+	}))
+
+	event = receiveEvent(t, events)
+	if event.Key != "jason" || event.OldCounter != jasonCounter ||
+		event.NewCounter <= jasonCounter {
+		t.Fatalf("event should be an update of jason, but is: %+v", event)
+	}
+
+	event = receiveEvent(t, adults)
+	if event.Key != "jason" || event.Op != OpSet {
+		t.Fatalf("event should be a set of jason, but is: %+v", event)
+	}
+
+	panicNotNil(db.Txn(func(tx *Tx) error {
+		if txErr := tx.Delete("watch_testing", "ben"); txErr != nil {
+			return txErr
+		}
+
+		return tx.Set("watch_testing", "drew", Person{Name: "Drew", Age: 5})
+	}))
+
+	event = receiveEvent(t, events)
+	if event.Key != "ben" || event.Op != OpDelete ||
+		event.OldCounter != benCounter || event.NewCounter != 0 {
+		t.Fatalf("event should be a delete of ben, but is: %+v", event)
+	}
+
+	event = receiveEvent(t, events)
+	if event.Key != "drew" || event.Op != OpSet {
+		t.Fatalf("event should be a set of drew, but is: %+v", event)
+	}
+
+	// The set of drew is outside of the watched range, so the delete of ben
+	// should be the only event received.
+	event = receiveEvent(t, adults)
+	if event.Key != "ben" || event.Op != OpDelete {
+		t.Fatalf("event should be a delete of ben, but is: %+v", event)
+	}
+
+	select {
+	case event = <-adults:
+		t.Fatalf("there should be no more events, but got: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("events channel should be closed, but isn't")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for events channel to close")
+	}
+
+	db.Close()
+
+	select {
+	case _, ok := <-adults:
+		if ok {
+			t.Fatal("events channel should be closed, but isn't")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for events channel to close")
+	}
+}
+
+func TestWatchOrder(t *testing.T) {
+	if testing.Short() {
+		t.Parallel()
+	}
+
+	dir, err := ioutil.TempDir("", "cete_")
+	panicNotNil(err)
+
+	t.Log("testing directory:", dir)
+	defer func() {
+		if !t.Failed() {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	db, err := Open(dir + "/data")
+	panicNotNil(err)
+
+	defer db.Close()
+
+	panicNotNil(db.NewTable("watch_order_testing"))
+	table := db.Table("watch_order_testing")
+
+	events := table.Watch(context.Background())
+	stalled := table.Watch(context.Background())
+
+	wg := new(sync.WaitGroup)
+	wg.Add(10)
+
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				panicNotNil(table.Set("jason", Person{Age: i*50 + j}))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	var counter uint64
+	for i := 0; i < 500; i++ {
+		event := receiveEvent(t, events)
+		if event.OldCounter != counter || event.NewCounter <= counter {
+			t.Fatalf("event should follow counter %d, but is: %+v", counter,
+				event)
+		}
+
+		counter = event.NewCounter
+	}
+
+	// The stalled watcher may have taken some of the events from its buffer,
+	// so its buffer is only known to be full after one more event.
+	for i := 0; i <= watchQueueLimit; i++ {
+		panicNotNil(table.Set(fmt.Sprintf("person%05d", i), Person{Age: i}))
+	}
+
+	for i := 0; i <= 500+watchQueueLimit; i++ {
+		if _, ok := <-stalled; !ok {
+			return
+		}
+	}
+
+	t.Fatal("stalled channel should be closed, but isn't")
+}